@@ -25,6 +25,7 @@ import (
 	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
 	"github.com/openebs/jiva-csi/pkg/config"
 	"github.com/openebs/jiva-csi/pkg/driver"
+	"github.com/openebs/jiva-csi/pkg/jiva"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/version"
 	"github.com/sirupsen/logrus"
@@ -99,6 +100,18 @@ func main() {
 		&config.PluginType, "plugin", "", "Type of this driver i.e. controller or node",
 	)
 
+	cmd.PersistentFlags().StringSliceVar(
+		&config.TopologyKeys, "topologykeys", []string{}, "Node label keys to be reported as topology segments of the node",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.JivaPort, "jivaport", jiva.DefaultPort, "Port of the REST API of the jiva controllers",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.JivaTimeout, "jivatimeout", jiva.DefaultTimeout, "Timeout of a request to the jiva controller REST API",
+	)
+
 	cmd.Flags().BoolVar(
 		&enableISCSIDebug, "enableiscsidebug", false, "Enable iscsi debug logs",
 	)
@@ -125,12 +138,13 @@ func run(config *config.Config) {
 
 	logrus.Infof("%s - %s", version.Version, version.Commit)
 	logrus.Infof(
-		"DriverName: %s Plugin: %s EndPoint: %s NodeID: %s, MaxRetryCount: %v",
+		"DriverName: %s Plugin: %s EndPoint: %s NodeID: %s, MaxRetryCount: %v, TopologyKeys: %v",
 		config.DriverName,
 		config.PluginType,
 		config.Endpoint,
 		config.NodeID,
 		driver.MaxRetryCount,
+		config.TopologyKeys,
 	)
 
 	if config.PluginType == "node" && enableISCSIDebug {
@@ -16,6 +16,8 @@ limitations under the License.
 
 package config
 
+import "time"
+
 // Config struct fills the parameters of request or user input
 type Config struct {
 	// DriverName to be registered at CSI
@@ -42,6 +44,20 @@ type Config struct {
 	// in case of topologies and publishing or
 	// unpublishing volumes on nodes
 	NodeID string
+
+	// TopologyKeys are the node label keys which are
+	// reported as the topology segments of the node.
+	// Volumes are provisioned with replicas placed on
+	// the nodes matching these segments
+	TopologyKeys []string
+
+	// JivaPort is the port of the REST API of the
+	// jiva controllers
+	JivaPort int
+
+	// JivaTimeout is the timeout of a request to
+	// the jiva controller REST API
+	JivaTimeout time.Duration
 }
 
 // Default returns a new instance of config
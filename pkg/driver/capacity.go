@@ -0,0 +1,167 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// poolCapacityExpiry is the age after which the pool capacity reported by
+// a node plugin is considered stale
+const poolCapacityExpiry = 5 * ReportPoolCapacityInterval * time.Second
+
+// availableCapacity returns the size of the largest jiva volume that can be
+// provisioned with the given replica StorageClass and replication factor on
+// the nodes matching the topology.
+//
+// Replicas of a jiva volume are placed on different nodes, so the volume can
+// only be as large as the free space on the RF-th emptiest node. Free space
+// of the pool backing the replica StorageClass on a node is reported by the
+// node plugin, it is the size of the pool minus the capacity of the PVs of
+// the StorageClass pinned to that node, capped by the space actually left
+// on the pool as jiva replicas are sparse.
+func (cs *controller) availableCapacity(
+	sc *storagev1.StorageClass,
+	rf int,
+	topology *csi.Topology,
+) (int64, error) {
+
+	if _, ok := hostpathBasePath(sc); !ok {
+		return 0, fmt.Errorf("capacity of StorageClass {%v} of provisioner {%v} is not known, "+
+			"only hostpath local PVs are supported", sc.Name, sc.Provisioner)
+	}
+
+	nodes, err := cs.client.ListNodes(topology.GetSegments())
+	if err != nil {
+		return 0, err
+	}
+
+	pvs, err := cs.client.ListPersistentVolumes()
+	if err != nil {
+		return 0, err
+	}
+
+	var free []int64
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Spec.Unschedulable || !isNodeAllowed(node, sc.AllowedTopologies) {
+			continue
+		}
+
+		pools, err := getPoolCapacity(node)
+		if err != nil {
+			logrus.Warningf("GetCapacity: %v", err)
+			continue
+		}
+
+		// pools of the nodes whose plugin is not running are
+		// not considered
+		pool, ok := pools[sc.Name]
+		if !ok || time.Since(pool.ReportedAt) > poolCapacityExpiry {
+			continue
+		}
+
+		avail := pool.Total
+		for j := range pvs.Items {
+			pv := &pvs.Items[j]
+			if pv.Spec.StorageClassName != sc.Name || !isPVOnNode(pv, node) {
+				continue
+			}
+			size := pv.Spec.Capacity[corev1.ResourceStorage]
+			avail -= size.Value()
+		}
+
+		if avail > pool.Available {
+			avail = pool.Available
+		}
+		if avail < 0 {
+			avail = 0
+		}
+		logrus.Debugf("GetCapacity: node {%v} has {%v} bytes available for StorageClass {%v}", node.Name, avail, sc.Name)
+		free = append(free, avail)
+	}
+
+	if rf <= 0 || len(free) < rf {
+		return 0, nil
+	}
+
+	sort.Slice(free, func(i, j int) bool {
+		return free[i] > free[j]
+	})
+	return free[rf-1], nil
+}
+
+// isNodeAllowed checks if the node satisfies the allowed topologies of the
+// StorageClass, node is allowed if it matches any one of the terms
+func isNodeAllowed(node *corev1.Node, terms []corev1.TopologySelectorTerm) bool {
+	if len(terms) == 0 {
+		return true
+	}
+
+	for _, term := range terms {
+		matched := true
+		for _, expr := range term.MatchLabelExpressions {
+			if !containsString(expr.Values, node.Labels[expr.Key]) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isPVOnNode checks if the PV is pinned to the given node through its node
+// affinity, as it is done for the local PVs used by jiva replicas
+func isPVOnNode(pv *corev1.PersistentVolume, node *corev1.Node) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return false
+	}
+
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			val, ok := node.Labels[expr.Key]
+			if !ok || expr.Operator != corev1.NodeSelectorOpIn {
+				continue
+			}
+
+			if containsString(expr.Values, val) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
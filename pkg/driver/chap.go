@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+)
+
+const chapSecretsType = "chap"
+
+// setCHAPSecrets sets the discovery and session CHAP credentials present
+// in the NodeStageVolume secrets on the connector, the connector must not
+// be logged after this
+func setCHAPSecrets(connector *iscsi.Connector, secrets map[string]string) {
+	if user := secrets[jivavolume.SessionCHAPUsernameKey]; user != "" {
+		connector.AuthType = chapSecretsType
+		connector.SessionSecrets = iscsi.Secrets{
+			SecretsType: chapSecretsType,
+			UserName:    user,
+			Password:    secrets[jivavolume.SessionCHAPPasswordKey],
+			UserNameIn:  secrets[jivavolume.SessionCHAPUsernameInKey],
+			PasswordIn:  secrets[jivavolume.SessionCHAPPasswordInKey],
+		}
+	}
+
+	if user := secrets[jivavolume.DiscoveryCHAPUsernameKey]; user != "" {
+		connector.DoCHAPDiscovery = true
+		connector.DiscoverySecrets = iscsi.Secrets{
+			SecretsType: chapSecretsType,
+			UserName:    user,
+			Password:    secrets[jivavolume.DiscoveryCHAPPasswordKey],
+			UserNameIn:  secrets[jivavolume.DiscoveryCHAPUsernameInKey],
+			PasswordIn:  secrets[jivavolume.DiscoveryCHAPPasswordInKey],
+		}
+	}
+}
@@ -18,19 +18,24 @@ package driver
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
-	"github.com/openebs/jiva-operator/pkg/jiva"
-	"github.com/openebs/jiva-operator/pkg/volume"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/cloud-provider/volume/helpers"
 )
@@ -38,8 +43,18 @@ import (
 // controller is the server implementation
 // for CSI Controller
 type controller struct {
+	driver       *CSIDriver
 	client       *client.Client
 	capabilities []*csi.ControllerServiceCapability
+
+	// jivaClient returns the REST client of the jiva controller
+	// serving at the given host
+	jivaClient func(host string, config jiva.Config) jiva.Interface
+
+	// mu protects expansions, the volumes being
+	// expanded by this controller
+	mu         sync.Mutex
+	expansions map[string]*expansion
 }
 
 // SupportedVolumeCapabilityAccessModes contains the list of supported access
@@ -48,28 +63,46 @@ var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	&csi.VolumeCapability_AccessMode{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	},
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	},
 }
 
-var SupportedVolumeCapabilityAccessType = []*csi.VolumeCapability_Mount{
-	&csi.VolumeCapability_Mount{
-		Mount: &csi.VolumeCapability_MountVolume{},
+// SupportedVolumeCapabilityAccessType contains the list of supported access
+// types (filesystem or raw block) for the volume
+var SupportedVolumeCapabilityAccessType = []*csi.VolumeCapability{
+	&csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+	},
+	&csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
 	},
 }
 
-var (
-	httpReqRetryCount    = 5
-	httpReqRetryInterval = 2 * time.Second
-)
-
 // NewController returns a new instance
 // of CSI controller
-func NewController(cli *client.Client) csi.ControllerServer {
+func NewController(d *CSIDriver, cli *client.Client) *controller {
 	return &controller{
+		driver:       d,
 		client:       cli,
 		capabilities: newControllerCapabilities(),
+		jivaClient:   newRESTClient,
+		expansions:   map[string]*expansion{},
 	}
 }
 
+// newRESTClient returns the REST client of the jiva controller
+func newRESTClient(host string, config jiva.Config) jiva.Interface {
+	return jiva.NewClient(host, config)
+}
+
 // CreateVolume provisions a volume
 func (cs *controller) CreateVolume(
 	ctx context.Context,
@@ -85,19 +118,130 @@ func (cs *controller) CreateVolume(
 		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
 	}
 
+	if err := cs.validateParameterRefs(req.GetParameters()); err != nil {
+		return nil, err
+	}
+
+	src := req.GetVolumeContentSource()
+	if snap := src.GetSnapshot(); snap != nil {
+		info, err := cs.getSnapshotSource(snap.GetSnapshotId())
+		if err != nil {
+			return nil, err
+		}
+
+		// replicas are seeded with the whole snapshot, so the volume
+		// is provisioned at least as large as the snapshot
+		reqSize := req.GetCapacityRange().GetRequiredBytes()
+		if reqSize == 0 {
+			req.CapacityRange = &csi.CapacityRange{
+				RequiredBytes: info.SizeBytes,
+				LimitBytes:    req.GetCapacityRange().GetLimitBytes(),
+			}
+		} else if reqSize < info.SizeBytes {
+			return nil, status.Errorf(codes.OutOfRange,
+				"CreateVolume: requested size {%v} is smaller than the snapshot size {%v}", reqSize, info.SizeBytes)
+		}
+	} else if vol := src.GetVolume(); vol != nil {
+		if err := cs.prepareCloneSource(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := cs.client.CreateJivaVolume(req); err != nil {
 		return nil, err
 	}
 
+	// Volume context carries the iSCSI target details, so wait till
+	// those are populated by jiva-operator, volumes with a content
+	// source are returned only once they are seeded from it
+	cond := isTargetCreated
+	if src != nil {
+		cond = isVolumeSeeded
+	}
+
+	volumeID := client.VolumeID(req)
+	instance, err := cs.waitForVolume(ctx, volumeID, cond)
+	if err != nil {
+		return nil, err
+	}
+
+	if src != nil {
+		if err := cs.completeSeeding(ctx, instance); err != nil {
+			return nil, err
+		}
+	}
+
+	size, err := capacityBytes(instance)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolume: %v", err)
+	}
+
 	logrus.Infof("CreateVolume: volume: {%v} is created", req.GetName())
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      req.GetName(),
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			VolumeId:      volumeID,
+			CapacityBytes: size,
+			VolumeContext: volumeContext(instance),
+			ContentSource: src,
+			AccessibleTopology: accessibleTopology(
+				req.GetAccessibilityRequirements(),
+			),
 		},
 	}, nil
 }
 
+// accessibleTopology returns the topologies from which the volume will be
+// accessible, replicas are placed on nodes matching any of the requisite
+// topologies or the preferred ones if none are required
+func accessibleTopology(req *csi.TopologyRequirement) []*csi.Topology {
+	if len(req.GetRequisite()) != 0 {
+		return req.GetRequisite()
+	}
+	return req.GetPreferred()
+}
+
+// waitForVolume polls the JivaVolume CR until the given condition is met,
+// the request is aborted if the CO cancels it in the meanwhile and it is
+// retried by the CO
+func (cs *controller) waitForVolume(
+	ctx context.Context,
+	volumeID string,
+	done func(*jv.JivaVolume) (bool, error),
+) (*jv.JivaVolume, error) {
+	for {
+		instance, err := cs.client.GetJivaVolume(volumeID)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := done(instance)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			return instance, nil
+		}
+
+		logrus.Infof("waiting for volume {%v} to be ready, phase: {%v}",
+			volumeID, instance.Status.Phase)
+		select {
+		case <-ctx.Done():
+			return nil, status.Errorf(codes.Aborted,
+				"volume {%v} is not ready yet, phase: {%v}",
+				volumeID, instance.Status.Phase)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// isTargetCreated checks if the iSCSI target details of the volume are
+// populated by jiva-operator
+func isTargetCreated(instance *jv.JivaVolume) (bool, error) {
+	if instance.Status.Phase == jv.JivaVolumePhaseFailed {
+		return false, status.Errorf(codes.Internal, "CreateVolume: failed to create volume {%v}", instance.Name)
+	}
+	return instance.Spec.ISCSISpec.Iqn != "" && instance.Spec.ISCSISpec.TargetIP != "", nil
+}
+
 // DeleteVolume deletes the specified volume
 func (cs *controller) DeleteVolume(
 	ctx context.Context,
@@ -115,6 +259,44 @@ func (cs *controller) DeleteVolume(
 		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
 	}
 
+	instance, err := cs.client.GetJivaVolume(volID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Infof("DeleteVolume: volume {%s} not found, ignore deletion", req.VolumeId)
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Replicas of the volumes being seeded from this volume copy the
+	// data from its replicas
+	seeding, err := cs.volumesSeededFrom(instance, "")
+	if err != nil {
+		return nil, err
+	} else if len(seeding) != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"DeleteVolume: volumes {%v} are still being seeded from volume {%v}", seeding, req.VolumeId)
+	}
+
+	// Volume may still be in use on a node if the unstage/unpublish
+	// calls haven't been completed yet
+	if inUse, reason := isVolumeInUse(instance); inUse {
+		if !isForceDelete(instance) {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"DeleteVolume: volume {%v} is still in use, %v", req.VolumeId, reason)
+		}
+		logrus.Warningf("DeleteVolume: force deleting volume {%v} which is still in use, %v", req.VolumeId, reason)
+	}
+
+	// Volumes provisioned with a retention period are only marked for
+	// deletion, they are purged by the reaper once the period is over
+	if isRetained(instance) && !isForceDelete(instance) {
+		if err := cs.softDeleteVolume(instance); err != nil {
+			return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to retain volume {%v}, err: {%v}", req.VolumeId, err)
+		}
+		logrus.Infof("DeleteVolume: volume {%s} is retained until {%v}", req.VolumeId, instance.Annotations[jivavolume.PurgeAfterKey])
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	if err := cs.client.DeleteJivaVolume(volID); err != nil {
 		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to delete volume {%v}, err: {%v}", req.VolumeId, err)
 	}
@@ -137,7 +319,7 @@ func (cs *controller) ValidateVolumeCapabilities(
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
-	volumeID = utils.StripName(volumeID)
+
 	volCaps := req.GetVolumeCapabilities()
 	if len(volCaps) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not provided")
@@ -176,56 +358,6 @@ func (cs *controller) ControllerGetCapabilities(
 	return resp, nil
 }
 
-func (cs *controller) isVolumeReady(volumeID string) (*jv.JivaVolume, error) {
-	var interval time.Duration = 0
-	var instance *jv.JivaVolume
-	var i int
-	for i = 0; i <= MaxRetryCount; i++ {
-		if i == MaxRetryCount {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: max retry count exceeded")
-		}
-		time.Sleep(interval * time.Second)
-		// set client each time to avoid caching issue
-		err := cs.client.Set()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to set client, err: %v", err)
-		}
-
-		instance, err = cs.client.GetJivaVolume(volumeID)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to get JivaVolume, err: %v", err)
-		}
-
-		interval = 5
-		repCount, rf := instance.Status.ReplicaCount, instance.Spec.Policy.Target.ReplicationFactor
-		if repCount != rf {
-			logrus.Warningf("All replicas are not up, RF: %v, ReplicaCount: %v", rf, repCount)
-			continue
-		}
-
-		statuses := instance.Status.ReplicaStatuses
-		if len(statuses) == 0 {
-			logrus.Warning("Replica's status is nil, volume must be initializing")
-			continue
-		}
-
-		cnt := 0
-		for _, rep := range statuses {
-			if rep.Mode == "RW" {
-				cnt++
-			} else {
-				return nil, status.Errorf(codes.Internal, "Replica: %s mode is %s", rep.Address, rep.Mode)
-			}
-		}
-
-		desired := rf
-		if cnt == desired {
-			break
-		}
-	}
-	return instance, nil
-}
-
 // ControllerExpandVolume resizes previously provisioned volume
 //
 // This implements csi.ControllerServer
@@ -238,78 +370,96 @@ func (cs *controller) ControllerExpandVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
 
-	volumeID = utils.StripName(volumeID)
-	jivaVolume, err := cs.isVolumeReady(volumeID)
-	if err != nil {
-		return nil, err
-	}
-
 	updatedSize := req.GetCapacityRange().GetRequiredBytes()
-	vol := volume.Volumes{}
-	ctrlIP := jivaVolume.Spec.ISCSISpec.TargetIP
-	if len(ctrlIP) == 0 {
-		return nil, status.Errorf(codes.Internal, "Target IP is nil")
-	}
-
-	cli := jiva.NewControllerClient(jivaVolume.Spec.ISCSISpec.TargetIP + ":9501")
-	cli.SetTimeout(30 * time.Second)
-	retryCount := 0
-	var httpErr error
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Get("/volumes", &vol)
-		if httpErr == nil {
-			break
-		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
+	if updatedSize <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Required capacity not provided")
 	}
 
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to get volume info from jiva controller, err: %v", httpErr)
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to set client, err: {%v}", err)
 	}
 
-	if len(vol.Data) == 0 {
-		return nil, status.Error(codes.Internal, "Failed to get volume info, no volume found")
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
 	}
 
 	size := resource.NewQuantity(updatedSize, resource.BinarySI)
 	volSizeGiB := helpers.RoundUpToGiB(*size)
 	capacity := fmt.Sprintf("%dGi", volSizeGiB)
-
-	input := volume.ResizeInput{
-		Name: vol.Data[0].Name,
-		Size: capacity,
+	newSize := volSizeGiB * helpers.GiB
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit > 0 && newSize > limit {
+		return nil, status.Errorf(codes.OutOfRange,
+			"ExpandVolume: capacity {%v} exceeds the limit {%v} bytes", capacity, limit)
 	}
 
-	retryCount = 0
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Post(vol.Data[0].Actions["resize"], input, nil)
-		if httpErr == nil {
-			break
-		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
+	curSize, err := capacityBytes(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to post resize request to jiva controller, err: %v", httpErr)
+	// jiva volumes can't be shrunk, the size in GiB may still be the same
+	// for a smaller request as the size is rounded up
+	if newSize < curSize {
+		return nil, status.Errorf(codes.OutOfRange,
+			"ExpandVolume: volume {%v} can't be shrunk from {%v} to {%v}", volumeID, instance.Spec.Capacity, capacity)
 	}
 
-	// set client each time to avoid caching issue
-	if err = cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: %v", err)
+	resp := &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         newSize,
+		NodeExpansionRequired: true,
 	}
 
-	jivaVolume.Spec.Capacity = capacity
-	err = cs.client.UpdateJivaVolume(jivaVolume)
+	info, err := getExpansion(instance)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &csi.ControllerExpandVolumeResponse{
-		CapacityBytes:         updatedSize,
-		NodeExpansionRequired: true,
-	}, nil
+	// The expansion is recorded on the CR before the volume is resized so
+	// that it is resumed even if the controller restarts in the meanwhile.
+	// An expansion which has failed or timed out is replaced by a larger
+	// one, as the CO keeps retrying with the latest size.
+	switch {
+	case info == nil && newSize == curSize:
+		logrus.Infof("ExpandVolume: volume {%v} is already of size {%v}", volumeID, instance.Spec.Capacity)
+		return resp, nil
+	case info != nil && info.Size != capacity && !cs.canReplaceExpansion(volumeID, info, newSize):
+		return nil, status.Errorf(codes.Aborted,
+			"ExpandVolume: expansion of volume {%v} to {%v} is already in progress", volumeID, info.Size)
+	case info == nil || info.Size != capacity:
+		if info != nil {
+			logrus.Infof("ExpandVolume: replacing expansion of volume {%v} to {%v}, last error: {%v}",
+				volumeID, info.Size, info.LastError)
+		}
+		info = &expansionInfo{
+			Size:      capacity,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := setExpansion(instance, info); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := cs.client.UpdateJivaVolume(instance); err != nil {
+			return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+		}
+	}
+
+	exp, started := cs.startExpansion(volumeID, capacity)
+	if !started {
+		return nil, status.Errorf(codes.Aborted,
+			"ExpandVolume: expansion of volume {%v} to {%v} is in progress", volumeID, capacity)
+	}
+
+	select {
+	case <-exp.done:
+		if exp.err != nil {
+			return nil, exp.err
+		}
+	case <-ctx.Done():
+		return nil, status.Errorf(codes.DeadlineExceeded,
+			"ExpandVolume: expansion of volume {%v} to {%v} is still in progress", volumeID, capacity)
+	}
+	return resp, nil
 }
 
 // CreateSnapshot creates a snapshot for given volume
@@ -320,7 +470,133 @@ func (cs *controller) CreateSnapshot(
 	req *csi.CreateSnapshotRequest,
 ) (*csi.CreateSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: snapshot name not provided")
+	}
+
+	volumeID := req.GetSourceVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: source volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	// snapshot names are unique across the volumes, so check if the
+	// snapshot has been taken already on any of the volumes
+	vols, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to list JivaVolumes, err: {%v}", err)
+	}
+
+	for _, vol := range vols.Items {
+		snaps, err := getSnapshots(&vol)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		info, ok := snaps[name]
+		if !ok {
+			continue
+		}
+
+		if !jivavolume.IsVolume(&vol, volumeID) {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"CreateSnapshot: snapshot {%v} already exists for a different volume {%v}", name, jivavolume.VolumeID(&vol))
+		}
+
+		if info.ReadyToUse {
+			snap, err := newCSISnapshot(volumeID, name, info)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			logrus.Infof("CreateSnapshot: snapshot {%v} of volume {%v} already exists", name, volumeID)
+			return &csi.CreateSnapshotResponse{Snapshot: snap}, nil
+		}
+	}
+
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.Status.Phase != jv.JivaVolumePhaseReady || instance.Status.Status != "RW" {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"CreateSnapshot: volume {%v} is not ready, phase: {%v}, status: {%v}",
+			volumeID, instance.Status.Phase, instance.Status.Status)
+	}
+
+	sizeBytes, err := capacityBytes(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	snaps, err := getSnapshots(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// record the snapshot before taking it, so that a retry after a
+	// partial failure still resolves to the same snapshot
+	info, ok := snaps[name]
+	if !ok {
+		info = snapshotInfo{
+			CreationTime: time.Now().UTC(),
+			SizeBytes:    sizeBytes,
+		}
+		snaps[name] = info
+		if err := setSnapshots(instance, snaps); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if err := cs.client.UpdateJivaVolume(instance); err != nil {
+			return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to record snapshot {%v}, err: {%v}", name, err)
+		}
+	}
+
+	cli, err := cs.newJivaClient(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("CreateSnapshot: creating snapshot {%v} of volume {%v}", name, volumeID)
+	if err := cli.Snapshot(ctx, name); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to create snapshot {%v}, err: {%v}", name, err)
+	}
+
+	// JivaVolume CR may be updated by jiva-operator
+	instance, err = cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps, err = getSnapshots(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	info.ReadyToUse = true
+	snaps[name] = info
+	if err := setSnapshots(instance, snaps); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to record snapshot {%v}, err: {%v}", name, err)
+	}
+
+	snap, err := newCSISnapshot(volumeID, name, info)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	logrus.Infof("CreateSnapshot: snapshot {%v} of volume {%v} is created", name, volumeID)
+	return &csi.CreateSnapshotResponse{Snapshot: snap}, nil
 }
 
 // DeleteSnapshot deletes given snapshot
@@ -331,7 +607,72 @@ func (cs *controller) DeleteSnapshot(
 	req *csi.DeleteSnapshotRequest,
 ) (*csi.DeleteSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	snapID := req.GetSnapshotId()
+	if len(snapID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot: snapshot ID not provided")
+	}
+
+	// From the spec: If a snapshot corresponding to the specified
+	// snapshot_id does not exist or the artifacts associated with the
+	// snapshot do not exist anymore, the Plugin MUST reply 0 OK.
+	volumeID, name, err := utils.ParseSnapshotID(snapID)
+	if err != nil {
+		logrus.Warningf("DeleteSnapshot: %v, ignore deletion...", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Warningf("DeleteSnapshot: volume {%v} not found, ignore deletion...", volumeID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	snaps, err := getSnapshots(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if _, ok := snaps[name]; !ok {
+		logrus.Warningf("DeleteSnapshot: snapshot {%v} not found, ignore deletion...", snapID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	seeding, err := cs.volumesSeededFrom(instance, name)
+	if err != nil {
+		return nil, err
+	} else if len(seeding) != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"DeleteSnapshot: volumes {%v} are still being seeded from snapshot {%v}", seeding, snapID)
+	}
+
+	cli, err := cs.newJivaClient(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("DeleteSnapshot: deleting snapshot {%v} of volume {%v}", name, volumeID)
+	if err := cli.DeleteSnapshot(ctx, name); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to delete snapshot {%v}, err: {%v}", name, err)
+	}
+
+	delete(snaps, name)
+	if err := setSnapshots(instance, snaps); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to remove snapshot {%v}, err: {%v}", name, err)
+	}
+
+	logrus.Infof("DeleteSnapshot: snapshot {%v} is deleted", snapID)
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 // ListSnapshots lists all snapshots for the
@@ -343,7 +684,90 @@ func (cs *controller) ListSnapshots(
 	req *csi.ListSnapshotsRequest,
 ) (*csi.ListSnapshotsResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ListSnapshots: failed to set client, err: {%v}", err)
+	}
+
+	var snapName string
+	volumeID := req.GetSourceVolumeId()
+	if snapID := req.GetSnapshotId(); snapID != "" {
+		vID, name, err := utils.ParseSnapshotID(snapID)
+		if err != nil || (volumeID != "" && volumeID != vID) {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		volumeID, snapName = vID, name
+	}
+
+	var vols []jv.JivaVolume
+	if volumeID != "" {
+		instance, err := cs.client.GetJivaVolume(volumeID)
+		if status.Code(err) == codes.NotFound {
+			return &csi.ListSnapshotsResponse{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		vols = append(vols, *instance)
+	} else {
+		list, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+			"openebs.io/component": "jiva-volume",
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "ListSnapshots: failed to list JivaVolumes, err: {%v}", err)
+		}
+		vols = list.Items
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, vol := range vols {
+		snaps, err := getSnapshots(&vol)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		srcVolumeID := volumeID
+		if srcVolumeID == "" {
+			srcVolumeID = jivavolume.VolumeID(&vol)
+		}
+
+		for name, info := range snaps {
+			if snapName != "" && name != snapName {
+				continue
+			}
+
+			snap, err := newCSISnapshot(srcVolumeID, name, info)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snap})
+		}
+	}
+
+	sortSnapshots(entries)
+
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(entries) {
+			return nil, status.Errorf(codes.Aborted, "ListSnapshots: invalid starting token {%v}", token)
+		}
+	}
+
+	end := len(entries)
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	var nextToken string
+	if end < len(entries) {
+		nextToken = strconv.Itoa(end)
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries[start:end],
+		NextToken: nextToken,
+	}, nil
 }
 
 // ControllerUnpublishVolume removes a previously
@@ -355,7 +779,56 @@ func (cs *controller) ControllerUnpublishVolume(
 	req *csi.ControllerUnpublishVolumeRequest,
 ) (*csi.ControllerUnpublishVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerUnpublishVolume: volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume: failed to set client, err: {%v}", err)
+	}
+
+	// From the spec: If the volume corresponding to the volume_id or the
+	// node corresponding to node_id cannot be found by the Plugin and the
+	// volume can be safely regarded as ControllerUnpublished from the node,
+	// the plugin SHOULD return 0 OK.
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Warningf("ControllerUnpublishVolume: volume {%v} not found, ignore unpublish...", volumeID)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	nodes, err := getPublishedNodes(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	nodeID := req.GetNodeId()
+	if _, ok := nodes[nodeID]; !ok && nodeID != "" {
+		logrus.Infof("ControllerUnpublishVolume: volume {%v} is not published on node {%v}", volumeID, nodeID)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	// empty node_id means the volume is to be unpublished from all the nodes
+	if nodeID == "" {
+		nodes = nil
+	} else {
+		delete(nodes, nodeID)
+	}
+
+	if err := setPublishedNodes(instance, nodes); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	logrus.Infof("ControllerUnpublishVolume: volume {%v} is unpublished from node {%v}", volumeID, nodeID)
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 // ControllerPublishVolume attaches given volume
@@ -367,7 +840,87 @@ func (cs *controller) ControllerPublishVolume(
 	req *csi.ControllerPublishVolumeRequest,
 ) (*csi.ControllerPublishVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume ID not provided")
+	}
+
+	nodeID := req.GetNodeId()
+	if len(nodeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: node ID not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume capability not provided")
+	}
+
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume capability not supported")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to set client, err: {%v}", err)
+	}
+
+	if _, err := cs.client.GetNode(nodeID); err != nil && errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: node {%v} not found", nodeID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to get node {%v}, err: {%v}", nodeID, err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSoftDeleted(instance) {
+		return nil, status.Errorf(codes.NotFound, "ControllerPublishVolume: volume {%v} is deleted", volumeID)
+	}
+
+	nodes, err := getPublishedNodes(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	info := publishInfo{
+		AccessMode: volCap.GetAccessMode().GetMode().String(),
+		Readonly:   req.GetReadonly(),
+	}
+
+	if published, ok := nodes[nodeID]; ok {
+		if published != info {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"ControllerPublishVolume: volume {%v} is already published on node {%v} with {%+v}", volumeID, nodeID, published)
+		}
+		logrus.Infof("ControllerPublishVolume: volume {%v} is already published on node {%v}", volumeID, nodeID)
+		return &csi.ControllerPublishVolumeResponse{}, nil
+	}
+
+	// the volume can't be logged in from more than one node
+	// if any of the publications is for single node access
+	for id, published := range nodes {
+		if isSingleNodeMode(info.AccessMode) || isSingleNodeMode(published.AccessMode) {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"ControllerPublishVolume: volume {%v} is already published on node {%v} with {%v}",
+				volumeID, id, published.AccessMode)
+		}
+	}
+
+	nodes[nodeID] = info
+	if err := setPublishedNodes(instance, nodes); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// concurrent publish requests for different nodes are serialized by the
+	// resource version of the CR, only one of them gets updated
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	logrus.Infof("ControllerPublishVolume: volume {%v} is published on node {%v}", volumeID, nodeID)
+	return &csi.ControllerPublishVolumeResponse{}, nil
 }
 
 // GetCapacity return the capacity of the
@@ -379,7 +932,37 @@ func (cs *controller) GetCapacity(
 	req *csi.GetCapacityRequest,
 ) (*csi.GetCapacityResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if volCaps := req.GetVolumeCapabilities(); len(volCaps) != 0 && !isValidVolumeCapabilities(volCaps) {
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	if err := validateVolumeParameters(req.GetParameters()); err != nil {
+		return nil, err
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to set client, err: {%v}", err)
+	}
+
+	policy, err := cs.client.GetVolumePolicySpec(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to get volume policy, err: {%v}", err)
+	}
+
+	sc, err := cs.client.GetStorageClass(policy.ReplicaSC)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to get replica StorageClass {%v}, err: {%v}", policy.ReplicaSC, err)
+	}
+
+	capacity, err := cs.availableCapacity(sc, policy.Target.ReplicationFactor, req.GetAccessibleTopology())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to compute capacity, err: {%v}", err)
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: capacity,
+	}, nil
 }
 
 // ListVolumes lists all the volumes
@@ -390,7 +973,130 @@ func (cs *controller) ListVolumes(
 	req *csi.ListVolumesRequest,
 ) (*csi.ListVolumesResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ListVolumes: failed to set client, err: {%v}", err)
+	}
+
+	list, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ListVolumes: failed to list JivaVolumes, err: {%v}", err)
+	}
+
+	// volumes are sorted by name so that the pagination tokens
+	// remain valid across ListVolumes calls
+	// volumes retained after deletion are not reported
+	vols := make([]jv.JivaVolume, 0, len(list.Items))
+	for _, vol := range list.Items {
+		if !isSoftDeleted(&vol) {
+			vols = append(vols, vol)
+		}
+	}
+	sort.Slice(vols, func(i, j int) bool {
+		return jivavolume.VolumeID(&vols[i]) < jivavolume.VolumeID(&vols[j])
+	})
+
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(vols) {
+			return nil, status.Errorf(codes.Aborted, "ListVolumes: invalid starting token {%v}", token)
+		}
+	}
+
+	end := len(vols)
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	entries := []*csi.ListVolumesResponse_Entry{}
+	for i := start; i < end; i++ {
+		vol := &vols[i]
+		size, err := capacityBytes(vol)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		nodes, err := publishedNodeIDs(vol)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      jivavolume.VolumeID(vol),
+				CapacityBytes: size,
+				VolumeContext: volumeContext(vol),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: nodes,
+				VolumeCondition:  volumeCondition(vol),
+			},
+		})
+	}
+
+	var nextToken string
+	if end < len(vols) {
+		nextToken = strconv.Itoa(end)
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// ControllerGetVolume returns the published nodes and the health of the
+// volume
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "ControllerGetVolume: volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSoftDeleted(instance) {
+		return nil, status.Errorf(codes.NotFound, "ControllerGetVolume: volume {%v} is deleted", volumeID)
+	}
+
+	size, err := capacityBytes(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	nodes, err := publishedNodeIDs(instance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      jivavolume.VolumeID(instance),
+			CapacityBytes: size,
+			VolumeContext: volumeContext(instance),
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: nodes,
+			VolumeCondition:  volumeCondition(instance),
+		},
+	}, nil
 }
 
 // IsSupportedVolumeCapabilityAccessMode valides the requested access mode
@@ -424,13 +1130,47 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 	var capabilities []*csi.ControllerServiceCapability
 	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 	} {
 		capabilities = append(capabilities, fromType(cap))
 	}
 	return capabilities
 }
 
+// volumeContext returns the attributes of the JivaVolume which are
+// reported to the CO as the volume context
+func volumeContext(instance *jv.JivaVolume) map[string]string {
+	ctx := map[string]string{
+		"namespace": instance.Namespace,
+	}
+
+	if policy := instance.Annotations["openebs.io/volume-policy"]; policy != "" {
+		ctx["policy"] = policy
+	}
+
+	if iqn := instance.Spec.ISCSISpec.Iqn; iqn != "" {
+		ctx["iqn"] = iqn
+	}
+
+	if ip := instance.Spec.ISCSISpec.TargetIP; ip != "" {
+		ctx["targetPortal"] = fmt.Sprintf("%v:%v", ip, instance.Spec.ISCSISpec.TargetPort)
+	}
+
+	for key, val := range jivavolume.ISCSIOptions(instance) {
+		ctx[key] = val
+	}
+	return ctx
+}
+
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	hasSupport := func(cap *csi.VolumeCapability) bool {
 		for _, c := range SupportedVolumeCapabilityAccessModes {
@@ -441,9 +1181,18 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 		return false
 	}
 
+	hasAccessType := func(cap *csi.VolumeCapability) bool {
+		for _, c := range SupportedVolumeCapabilityAccessType {
+			if reflect.TypeOf(c.GetAccessType()) == reflect.TypeOf(cap.GetAccessType()) {
+				return true
+			}
+		}
+		return false
+	}
+
 	foundAll := true
 	for _, c := range volCaps {
-		if !hasSupport(c) {
+		if !hasSupport(c) || !hasAccessType(c) {
 			foundAll = false
 		}
 	}
@@ -472,5 +1221,37 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 			"Failed to validate volume capabilities")
 	}
 
+	if err := validateVolumeParameters(req.GetParameters()); err != nil {
+		return err
+	}
+
+	if err := jivavolume.ValidateCHAPSecrets(req.GetSecrets()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid CHAP secret, err: {%v}", err)
+	}
+
+	if src := req.GetVolumeContentSource(); src != nil {
+		switch src.GetType().(type) {
+		case *csi.VolumeContentSource_Snapshot:
+			if src.GetSnapshot().GetSnapshotId() == "" {
+				return status.Error(
+					codes.InvalidArgument,
+					"Failed to validate content source: missing snapshot ID",
+				)
+			}
+		case *csi.VolumeContentSource_Volume:
+			if src.GetVolume().GetVolumeId() == "" {
+				return status.Error(
+					codes.InvalidArgument,
+					"Failed to validate content source: missing volume ID",
+				)
+			}
+		default:
+			return status.Errorf(
+				codes.InvalidArgument,
+				"Failed to validate content source: unsupported type {%T}", src.GetType(),
+			)
+		}
+	}
+
 	return nil
 }
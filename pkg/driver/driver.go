@@ -50,6 +50,8 @@ type CSIDriver struct {
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	supported := []csi.VolumeCapability_AccessMode_Mode{
 		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	}
 
 	var vcams []*csi.VolumeCapability_AccessMode
@@ -73,10 +75,16 @@ func New(config *config.Config, cli *client.Client) *CSIDriver {
 
 	switch config.PluginType {
 	case "controller":
-		driver.cs = NewController(cli)
+		cs := NewController(driver, cli)
+		go cs.ReapVolumes()
+		go cs.ResumeExpansions()
+		go cs.MonitorVolumes()
+		driver.cs = cs
 
 	case "node":
 		ns := NewNode(driver, cli)
+		go ns.MonitorRekeys()
+		go ns.ReportPoolCapacity()
 		remount := os.Getenv("REMOUNT")
 		if remount == "true" || remount == "True" {
 			nm := newNodeMounterWithOpts(
@@ -0,0 +1,277 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// ResumeExpansionsInterval is the time gap in seconds between two
+	// consecutive retries of the pending expansions
+	ResumeExpansionsInterval = 60
+
+	// expansionAnnotation is the JivaVolume annotation under which the
+	// expansion in progress is recorded, so that it can be resumed if the
+	// controller restarts before it is completed
+	expansionAnnotation = "openebs.io/expansion"
+
+	// expansionTimeout is the time within which an expansion is expected
+	// to be completed, it is retried by ResumeExpansions otherwise
+	expansionTimeout = 10 * time.Minute
+)
+
+// expansionInfo is the expansion of the volume in progress
+type expansionInfo struct {
+	Size      string `json:"size"`
+	StartedAt string `json:"startedAt"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// expansion is an expansion being run by this controller, done is closed
+// once it is completed
+type expansion struct {
+	done chan struct{}
+	err  error
+}
+
+// getExpansion returns the expansion in progress recorded on the
+// JivaVolume CR, nil is returned if there is none
+func getExpansion(instance *jv.JivaVolume) (*expansionInfo, error) {
+	val, ok := instance.Annotations[expansionAnnotation]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	info := &expansionInfo{}
+	if err := json.Unmarshal([]byte(val), info); err != nil {
+		return nil, fmt.Errorf("failed to decode expansion of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	return info, nil
+}
+
+// setExpansion records the expansion on the JivaVolume CR, nil clears it,
+// it doesn't update the CR
+func setExpansion(instance *jv.JivaVolume, info *expansionInfo) error {
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+
+	if info == nil {
+		delete(instance.Annotations, expansionAnnotation)
+		return nil
+	}
+
+	val, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode expansion of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	instance.Annotations[expansionAnnotation] = string(val)
+	return nil
+}
+
+// startExpansion runs the expansion of the volume to the given size in
+// background unless it is already running, the returned flag is false if
+// it was already running
+func (cs *controller) startExpansion(volumeID, size string) (*expansion, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if exp, ok := cs.expansions[volumeID]; ok {
+		return exp, false
+	}
+
+	exp := &expansion{done: make(chan struct{})}
+	cs.expansions[volumeID] = exp
+	go func() {
+		exp.err = cs.expandVolume(volumeID, size)
+		cs.mu.Lock()
+		delete(cs.expansions, volumeID)
+		cs.mu.Unlock()
+		close(exp.done)
+	}()
+	return exp, true
+}
+
+// canReplaceExpansion checks if the recorded expansion can be replaced by
+// an expansion to the given larger size, which is the case if it is not
+// running on this controller and it has either failed or timed out
+func (cs *controller) canReplaceExpansion(volumeID string, info *expansionInfo, newSize int64) bool {
+	cs.mu.Lock()
+	_, running := cs.expansions[volumeID]
+	cs.mu.Unlock()
+	if running {
+		return false
+	}
+
+	if size, err := resource.ParseQuantity(info.Size); err == nil && newSize <= size.Value() {
+		return false
+	}
+
+	startedAt, err := time.Parse(time.RFC3339, info.StartedAt)
+	stale := err != nil || time.Since(startedAt) > expansionTimeout
+	return info.LastError != "" || stale
+}
+
+// expandVolume resizes the volume on the jiva controller and then updates
+// the capacity of the JivaVolume CR, the failure is recorded on the CR and
+// the expansion is retried by ResumeExpansions or the next expand request
+func (cs *controller) expandVolume(volumeID, size string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), expansionTimeout)
+	defer cancel()
+
+	logrus.Infof("ExpandVolume: expanding volume {%v} to {%v}", volumeID, size)
+	err := cs.resizeVolume(ctx, volumeID, size)
+	if err == nil {
+		logrus.Infof("ExpandVolume: volume {%v} is expanded to {%v}", volumeID, size)
+		return nil
+	}
+
+	logrus.Errorf("ExpandVolume: failed to expand volume {%v} to {%v}, err: {%v}", volumeID, size, err)
+	if recordErr := cs.updateExpansion(volumeID, func(_ *jv.JivaVolume, info *expansionInfo) {
+		info.LastError = err.Error()
+	}); recordErr != nil {
+		logrus.Warningf("ExpandVolume: failed to record error of volume {%v}, err: {%v}", volumeID, recordErr)
+	}
+	return err
+}
+
+func (cs *controller) resizeVolume(ctx context.Context, volumeID, size string) error {
+	if err := cs.client.Set(); err != nil {
+		return status.Errorf(codes.Internal, "ExpandVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.waitForVolume(ctx, volumeID, areReplicasReady)
+	if err != nil {
+		return err
+	}
+
+	cli, err := cs.newJivaClient(instance)
+	if err != nil {
+		return err
+	}
+
+	// resizing to the size already in place is a no-op for jiva, so the
+	// resize is posted again if the expansion is resumed
+	if err := cli.Resize(ctx, size); err != nil {
+		return status.Errorf(codes.Internal, "ExpandVolume: failed to resize volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	return cs.updateExpansion(volumeID, func(instance *jv.JivaVolume, info *expansionInfo) {
+		instance.Spec.Capacity = size
+	})
+}
+
+// updateExpansion applies the change to the expansion recorded on the
+// JivaVolume CR, the expansion is cleared if the capacity of the volume
+// is updated to the size being expanded to
+func (cs *controller) updateExpansion(volumeID string, update func(*jv.JivaVolume, *expansionInfo)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cs.client.GetJivaVolume(volumeID)
+		if err != nil {
+			return err
+		}
+
+		info, err := getExpansion(instance)
+		if err != nil || info == nil {
+			return err
+		}
+
+		update(instance, info)
+		if instance.Spec.Capacity == info.Size {
+			info = nil
+		}
+
+		if err := setExpansion(instance, info); err != nil {
+			return err
+		}
+		return cs.client.UpdateJivaVolume(instance)
+	})
+}
+
+// areReplicasReady checks if all the replicas of the volume are up and in
+// RW mode, volume can only be resized in that state
+func areReplicasReady(instance *jv.JivaVolume) (bool, error) {
+	repCount, rf := instance.Status.ReplicaCount, instance.Spec.Policy.Target.ReplicationFactor
+	if repCount != rf {
+		logrus.Warningf("All replicas are not up, RF: %v, ReplicaCount: %v", rf, repCount)
+		return false, nil
+	}
+
+	statuses := instance.Status.ReplicaStatuses
+	if len(statuses) == 0 {
+		logrus.Warning("Replica's status is nil, volume must be initializing")
+		return false, nil
+	}
+
+	for _, rep := range statuses {
+		if rep.Mode != "RW" {
+			logrus.Warningf("Replica: %s mode is %s, waiting for it to be RW", rep.Address, rep.Mode)
+			return false, nil
+		}
+	}
+	return len(statuses) == rf, nil
+}
+
+// ResumeExpansions resumes the expansions which were in progress when the
+// controller was restarted and retries the ones which have failed.
+// This function runs a never ending loop therefore should be run as a goroutine
+func (cs *controller) ResumeExpansions() {
+	logrus.Infof("Starting ResumeExpansions goroutine")
+	ticker := time.NewTicker(ResumeExpansionsInterval * time.Second)
+	for ; true; <-ticker.C {
+		// reset the client to avoid caching issue
+		if err := cs.client.Set(); err != nil {
+			logrus.Warningf("ResumeExpansions: failed to set client, err: {%v}", err)
+			continue
+		}
+
+		list, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+			"openebs.io/component": "jiva-volume",
+		})
+		if err != nil {
+			logrus.Warningf("ResumeExpansions: failed to list JivaVolumes, err: {%v}", err)
+			continue
+		}
+
+		for i := range list.Items {
+			vol := &list.Items[i]
+			info, err := getExpansion(vol)
+			if err != nil {
+				logrus.Warningf("ResumeExpansions: %v", err)
+				continue
+			} else if info == nil {
+				continue
+			}
+
+			if _, started := cs.startExpansion(jivavolume.VolumeID(vol), info.Size); started {
+				logrus.Infof("ResumeExpansions: resuming expansion of volume {%v} to {%v}", vol.Name, info.Size)
+			}
+		}
+	}
+}
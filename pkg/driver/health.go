@@ -0,0 +1,62 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+)
+
+// volumeCondition returns the health of the volume as observed by
+// jiva-operator from the target and the replicas, volume is abnormal if
+// it is read-only or any of its replicas is not connected or healthy
+func volumeCondition(instance *jv.JivaVolume) *csi.VolumeCondition {
+	st := instance.Status
+	switch {
+	case st.Phase == jv.JivaVolumePhaseFailed:
+		return abnormalCondition("volume is in %s phase", st.Phase)
+	case st.Phase == jv.JivaVolumePhasePending:
+		return abnormalCondition("volume is not provisioned yet")
+	case st.Status == "RO":
+		return abnormalCondition("volume is read-only, %d of %d replicas are connected",
+			st.ReplicaCount, instance.Spec.Policy.Target.ReplicationFactor)
+	}
+
+	for _, rep := range st.ReplicaStatuses {
+		if rep.Mode != "RW" {
+			return abnormalCondition("replica %s is in %s mode", rep.Address, rep.Mode)
+		}
+	}
+
+	if rf := instance.Spec.Policy.Target.ReplicationFactor; st.ReplicaCount < rf {
+		return abnormalCondition("volume is degraded, %d of %d replicas are connected", st.ReplicaCount, rf)
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  fmt.Sprintf("volume is healthy, status: %s", st.Status),
+	}
+}
+
+func abnormalCondition(format string, args ...interface{}) *csi.VolumeCondition {
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
@@ -100,13 +100,13 @@ func (id *identity) GetPluginCapabilities(
 					},
 				},
 			},
-			/*			{
-							Type: &csi.PluginCapability_Service_{
-								Service: &csi.PluginCapability_Service{
-									Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-								},
-							},
-						},
-			*/},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
+		},
 	}, nil
 }
@@ -0,0 +1,282 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	"github.com/openebs/jiva-csi/pkg/request"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	// MonitorRekeysInterval is the time gap in seconds between two
+	// consecutive checks for the rekey requests of the volumes staged on
+	// the node
+	MonitorRekeysInterval = 30
+
+	// luksMapperPrefix is the prefix of the device mapper name of the
+	// opened LUKS device
+	luksMapperPrefix = "luks-"
+)
+
+// luksMapperName returns the device mapper name of the opened LUKS
+// device of the volume
+func luksMapperName(instance *jv.JivaVolume) string {
+	return luksMapperPrefix + instance.Name
+}
+
+// luksDevicePath returns the path of the opened LUKS device of the volume
+func luksDevicePath(instance *jv.JivaVolume) string {
+	return filepath.Join("/dev/mapper", luksMapperName(instance))
+}
+
+// cryptsetup runs cryptsetup through the exec of the mounter with the
+// passphrase passed on stdin, so that it never shows up in the process
+// list or in the logs
+func (ns *node) cryptsetup(passphrase string, args ...string) ([]byte, error) {
+	e, ok := ns.mounter.Exec.(inputExec)
+	if !ok {
+		return nil, fmt.Errorf("exec of the mounter can't pass the passphrase to cryptsetup")
+	}
+	return e.RunWithInput(passphrase, "cryptsetup", args...)
+}
+
+// openLUKS opens the LUKS device of the volume and returns the path of the
+// opened device, a fresh device is formatted as LUKS first. Devices which
+// already have any other content on them are never formatted.
+func (ns *node) openLUKS(instance *jv.JivaVolume, devicePath, passphrase string, readOnly bool) (string, error) {
+	mapperPath := luksDevicePath(instance)
+	if _, err := os.Stat(mapperPath); err == nil {
+		logrus.Infof("NodeStageVolume: LUKS device {%v} is already open", mapperPath)
+		return mapperPath, nil
+	}
+
+	if _, err := ns.mounter.Exec.Run("cryptsetup", "isLuks", devicePath); err != nil {
+		if readOnly {
+			return "", fmt.Errorf("device {%v} is not a LUKS device, read-only volumes are never formatted", devicePath)
+		}
+
+		format, err := ns.mounter.GetDiskFormat(devicePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to get format of device {%v}, err: {%v}", devicePath, err)
+		}
+		if format != "" {
+			return "", fmt.Errorf("device {%v} already has {%v} on it, refusing to format it as LUKS", devicePath, format)
+		}
+
+		// plain luks is LUKS2 from cryptsetup 2.1, LUKS1 is asked for
+		// explicitly as it can be resized without the passphrase
+		logrus.Infof("NodeStageVolume: formatting device {%v} as LUKS1", devicePath)
+		if out, err := ns.cryptsetup(passphrase, "luksFormat", "--type", "luks1", "--batch-mode",
+			"--key-file=-", devicePath); err != nil {
+			return "", fmt.Errorf("failed to format device {%v} as LUKS, err: {%v}, output: {%s}", devicePath, err, out)
+		}
+	}
+
+	args := []string{"luksOpen", "--key-file=-", devicePath, luksMapperName(instance)}
+	if readOnly {
+		args = append(args, "--readonly")
+	}
+	if out, err := ns.cryptsetup(passphrase, args...); err != nil {
+		return "", fmt.Errorf("failed to open LUKS device {%v}, err: {%v}, output: {%s}", devicePath, err, out)
+	}
+	return mapperPath, nil
+}
+
+// closeLUKS closes the LUKS device of the volume if it is open
+func (ns *node) closeLUKS(instance *jv.JivaVolume) error {
+	mapperPath := luksDevicePath(instance)
+	if _, err := os.Stat(mapperPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	logrus.Infof("NodeUnstageVolume: closing LUKS device {%v}", mapperPath)
+	if out, err := ns.mounter.Exec.Run("cryptsetup", "luksClose", luksMapperName(instance)); err != nil {
+		return fmt.Errorf("failed to close LUKS device {%v}, err: {%v}, output: {%s}", mapperPath, err, out)
+	}
+	return nil
+}
+
+// luksBackingDevice returns the device on which the opened LUKS device is
+// set up
+func luksBackingDevice(exec mount.Exec, name string) (string, error) {
+	out, err := exec.Run("cryptsetup", "status", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get status of LUKS device {%v}, err: {%v}, output: {%s}", name, err, out)
+	}
+
+	//   device:  /dev/sdb
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "device:" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("device of LUKS device {%v} not found in {%s}", name, out)
+}
+
+// MonitorRekeys changes the LUKS passphrase of the encrypted volumes
+// staged on the node whose JivaVolume is annotated with the rekey secret.
+// The annotation is removed once the passphrase is changed, after which
+// the NodeStageVolume secret must be updated with the new passphrase.
+// This function runs a never ending loop therefore should be run as a goroutine
+func (ns *node) MonitorRekeys() {
+	logrus.Infof("Starting MonitorRekeys goroutine")
+	ticker := time.NewTicker(MonitorRekeysInterval * time.Second)
+	for range ticker.C {
+		// reset the client to avoid caching issue
+		if err := ns.client.Set(); err != nil {
+			logrus.Warningf("MonitorRekeys: failed to set client, err: {%v}", err)
+			continue
+		}
+
+		// volumes staged read-only are not labelled with the node
+		list, err := ns.client.ListJivaVolumeWithOpts(map[string]string{
+			"openebs.io/component": "jiva-volume",
+		})
+		if err != nil {
+			logrus.Warningf("MonitorRekeys: failed to list JivaVolumes, err: {%v}", err)
+			continue
+		}
+
+		for i := range list.Items {
+			vol := &list.Items[i]
+			if !jivavolume.IsEncrypted(vol) || vol.Annotations[jivavolume.RekeySecretKey] == "" ||
+				rekeyNode(vol) != ns.driver.config.NodeID {
+				continue
+			}
+
+			// node RPCs may be closing the device
+			volumeID := jivavolume.VolumeID(vol)
+			if err := request.AddVolumeToTransitionList(volumeID, "Rekey"); err != nil {
+				continue
+			}
+
+			if err := ns.rekeyVolume(vol); err != nil {
+				logrus.Errorf("MonitorRekeys: failed to rekey volume {%v}, err: {%v}", volumeID, err)
+			} else {
+				logrus.Infof("MonitorRekeys: changed LUKS passphrase of volume {%v}", volumeID)
+			}
+			request.RemoveVolumeFromTransitionList(volumeID)
+		}
+	}
+}
+
+// rekeyNode returns the node which changes the passphrase of the volume,
+// the LUKS header is shared by all the nodes on which the volume is staged
+// so it is the node on which it is staged rw, or the first of the nodes on
+// which it is staged read-only
+func rekeyNode(instance *jv.JivaVolume) string {
+	if nodeID := instance.Labels["nodeID"]; nodeID != "" {
+		return nodeID
+	}
+
+	ids, err := readOnlyNodeIDs(instance)
+	if err != nil || len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+// rekeySecretName returns the name of the rekey secret of the volume, the
+// secret is read from the namespace of the JivaVolume as the node plugin is
+// only allowed to read the secrets of that namespace
+func rekeySecretName(instance *jv.JivaVolume) (string, error) {
+	ref := instance.Annotations[jivavolume.RekeySecretKey]
+	name := ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		if ref[:i] != instance.Namespace {
+			return "", fmt.Errorf("invalid %s {%v}, secret must be in namespace {%v}",
+				jivavolume.RekeySecretKey, ref, instance.Namespace)
+		}
+		name = ref[i+1:]
+	}
+
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid %s {%v}, must be the name of a secret", jivavolume.RekeySecretKey, ref)
+	}
+	return name, nil
+}
+
+// rekeyVolume replaces the LUKS passphrase of the volume with the new one
+// from the rekey secret and clears the rekey annotation
+func (ns *node) rekeyVolume(instance *jv.JivaVolume) error {
+	ref := instance.Annotations[jivavolume.RekeySecretKey]
+	name, err := rekeySecretName(instance)
+	if err != nil {
+		return err
+	}
+
+	secret, err := ns.client.GetSecret(name, instance.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to get rekey secret {%v}, err: {%v}", ref, err)
+	}
+
+	oldKey := string(secret.Data[jivavolume.EncryptionPassphraseKey])
+	newKey := string(secret.Data[jivavolume.NewEncryptionPassphraseKey])
+	if oldKey == "" || newKey == "" {
+		return fmt.Errorf("rekey secret {%v} must have both %s and %s", ref,
+			jivavolume.EncryptionPassphraseKey, jivavolume.NewEncryptionPassphraseKey)
+	}
+
+	device, err := luksBackingDevice(ns.mounter.Exec, luksMapperName(instance))
+	if err != nil {
+		return err
+	}
+
+	// passphrase may have been changed already, if the annotation
+	// couldn't be cleared last time
+	if _, err := ns.cryptsetup(newKey, "luksOpen", "--test-passphrase", "--key-file=-", device); err != nil {
+		if err := ns.changeLUKSKey(device, oldKey, newKey); err != nil {
+			return err
+		}
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vol, err := ns.client.GetJivaVolume(jivavolume.VolumeID(instance))
+		if err != nil {
+			return err
+		}
+		delete(vol.Annotations, jivavolume.RekeySecretKey)
+		vol.Annotations[jivavolume.RekeyedAtKey] = time.Now().UTC().Format(time.RFC3339)
+		return ns.client.UpdateJivaVolume(vol)
+	})
+}
+
+// changeLUKSKey replaces the key slot of the old passphrase with the new
+// one. Both of them are passed on stdin, one per line, as cryptsetup reads
+// them when no key file is given, so none of them is written to the disk.
+func (ns *node) changeLUKSKey(device, oldKey, newKey string) error {
+	if strings.ContainsAny(oldKey+newKey, "\r\n") {
+		return fmt.Errorf("LUKS passphrase of device {%v} can't be changed, passphrases must not have line breaks", device)
+	}
+
+	input := oldKey + "\n" + newKey + "\n"
+	if out, err := ns.cryptsetup(input, "luksChangeKey", "--batch-mode", device); err != nil {
+		return fmt.Errorf("failed to change LUKS passphrase of device {%v}, err: {%v}, output: {%s}", device, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	// MonitorVolumesInterval is the time gap in seconds after which the
+	// watch of the volumes is restarted if it fails
+	MonitorVolumesInterval = 30
+
+	// events posted on the PV and PVC of the volume
+	reasonVolumeNotReady      = "VolumeNotReady"
+	reasonVolumeReady         = "VolumeReady"
+	reasonVolumeReadOnly      = "VolumeReadOnly"
+	reasonVolumeReadWrite     = "VolumeReadWrite"
+	reasonReplicaDisconnected = "ReplicaDisconnected"
+	reasonReplicaConnected    = "ReplicaConnected"
+	reasonReplicaDegraded     = "ReplicaDegraded"
+)
+
+// volumeHealth is the health of the volume observed by the monitor
+type volumeHealth struct {
+	phase        jv.JivaVolumePhase
+	status       string
+	replicaCount int
+	replicas     map[string]string
+}
+
+// volumeEvent is an event to be posted for a change in volume health
+type volumeEvent struct {
+	eventType string
+	reason    string
+	message   string
+}
+
+func newVolumeHealth(instance *jv.JivaVolume) volumeHealth {
+	health := volumeHealth{
+		phase:        instance.Status.Phase,
+		status:       instance.Status.Status,
+		replicaCount: instance.Status.ReplicaCount,
+		replicas:     map[string]string{},
+	}
+	for _, rep := range instance.Status.ReplicaStatuses {
+		health.replicas[rep.Address] = rep.Mode
+	}
+	return health
+}
+
+// String returns the replica addresses along with their modes
+func (h volumeHealth) String() string {
+	var reps []string
+	for addr, mode := range h.replicas {
+		reps = append(reps, fmt.Sprintf("%s (%s)", addr, mode))
+	}
+	sort.Strings(reps)
+	return fmt.Sprintf("phase: %s, status: %s, replicas: [%s]", h.phase, h.status, strings.Join(reps, ", "))
+}
+
+// healthEvents returns the events for the changes from the previously
+// observed health of the volume
+func healthEvents(prev, cur volumeHealth, rf int) []volumeEvent {
+	var events []volumeEvent
+	warn := func(reason, format string, args ...interface{}) {
+		events = append(events, volumeEvent{corev1.EventTypeWarning, reason, fmt.Sprintf(format, args...)})
+	}
+	normal := func(reason, format string, args ...interface{}) {
+		events = append(events, volumeEvent{corev1.EventTypeNormal, reason, fmt.Sprintf(format, args...)})
+	}
+
+	// volumes which are still being provisioned aren't reported, the
+	// state in which they come up is their initial state
+	if cur.phase == jv.JivaVolumePhasePending || cur.phase == "" ||
+		prev.phase == jv.JivaVolumePhasePending || prev.phase == "" {
+		return nil
+	}
+
+	if prev.phase != cur.phase {
+		if cur.phase == jv.JivaVolumePhaseReady {
+			normal(reasonVolumeReady, "Volume is ready, %s", cur)
+		} else if prev.phase == jv.JivaVolumePhaseReady {
+			warn(reasonVolumeNotReady, "Volume phase changed from %s to %s, %s", prev.phase, cur.phase, cur)
+		}
+	}
+
+	if prev.status != cur.status {
+		if cur.status == "RO" {
+			warn(reasonVolumeReadOnly, "Volume is read-only, %s", cur)
+		} else if prev.status == "RO" && cur.status == "RW" {
+			normal(reasonVolumeReadWrite, "Volume is read-write again, %s", cur)
+		}
+	}
+
+	if cur.replicaCount < prev.replicaCount {
+		warn(reasonReplicaDisconnected, "Replicas dropped from %d to %d of %d, %s", prev.replicaCount, cur.replicaCount, rf, cur)
+	} else if cur.replicaCount > prev.replicaCount && prev.replicaCount < rf {
+		normal(reasonReplicaConnected, "Replicas increased from %d to %d of %d, %s", prev.replicaCount, cur.replicaCount, rf, cur)
+	}
+
+	var degraded []string
+	for addr, mode := range cur.replicas {
+		if mode != "RW" && prev.replicas[addr] != mode {
+			degraded = append(degraded, fmt.Sprintf("%s (%s)", addr, mode))
+		}
+	}
+	if len(degraded) != 0 {
+		sort.Strings(degraded)
+		warn(reasonReplicaDegraded, "Replicas %s are not in RW mode, %s", strings.Join(degraded, ", "), cur)
+	}
+	return events
+}
+
+// MonitorVolumes watches the status of the JivaVolumes updated by
+// jiva-operator and posts events on the PV and the PVC of the volume when
+// its health changes, like replicas getting disconnected or the volume
+// turning read-only. The state of the volumes found at startup and of the
+// new volumes is recorded without posting any events.
+// This function runs a never ending loop therefore should be run as a goroutine
+func (cs *controller) MonitorVolumes() {
+	logrus.Infof("Starting MonitorVolumes goroutine")
+	handler := toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: cs.volumeUpdated,
+	}
+
+	stop := make(chan struct{})
+	for {
+		if err := cs.client.WatchJivaVolumes(handler, stop); err != nil {
+			logrus.Warningf("MonitorVolumes: failed to watch JivaVolumes, err: {%v}", err)
+		}
+		time.Sleep(MonitorVolumesInterval * time.Second)
+	}
+}
+
+// volumeUpdated posts the events for the change in the health of the
+// volume, the informer calls it on resyncs as well in which case nothing
+// has changed
+func (cs *controller) volumeUpdated(oldObj, newObj interface{}) {
+	prev, ok := oldObj.(*jv.JivaVolume)
+	if !ok {
+		return
+	}
+	vol, ok := newObj.(*jv.JivaVolume)
+	if !ok || vol.Labels["openebs.io/component"] != "jiva-volume" || isSoftDeleted(vol) {
+		return
+	}
+
+	volumeID := jivavolume.VolumeID(vol)
+	for _, ev := range healthEvents(newVolumeHealth(prev), newVolumeHealth(vol), vol.Spec.Policy.Target.ReplicationFactor) {
+		logrus.Infof("MonitorVolumes: volume {%v}: %v: %v", volumeID, ev.reason, ev.message)
+		cs.postVolumeEvent(vol, ev)
+	}
+}
+
+// postVolumeEvent posts the event on the PV of the volume and on the PVC
+// bound to it
+func (cs *controller) postVolumeEvent(instance *jv.JivaVolume, ev volumeEvent) {
+	pv, err := cs.client.GetPersistentVolume(jivavolume.VolumeName(instance))
+	if err != nil {
+		logrus.Warningf("MonitorVolumes: failed to get PV of volume {%v}, err: {%v}", instance.Name, err)
+		return
+	}
+
+	refs := []corev1.ObjectReference{{
+		Kind:       "PersistentVolume",
+		APIVersion: "v1",
+		Name:       pv.Name,
+		UID:        pv.UID,
+	}}
+	if claim := pv.Spec.ClaimRef; claim != nil {
+		refs = append(refs, corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Name:       claim.Name,
+			Namespace:  claim.Namespace,
+			UID:        claim.UID,
+		})
+	}
+
+	now := metav1.Now()
+	for _, ref := range refs {
+		// events of cluster scoped objects are created in
+		// the default namespace
+		ns := ref.Namespace
+		if ns == "" {
+			ns = metav1.NamespaceDefault
+		}
+
+		event := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+				Namespace: ns,
+			},
+			InvolvedObject: ref,
+			Reason:         ev.reason,
+			Message:        ev.message,
+			Type:           ev.eventType,
+			Source: corev1.EventSource{
+				Component: cs.driver.config.DriverName,
+			},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+
+		if err := cs.client.CreateEvent(event); err != nil {
+			logrus.Warningf("MonitorVolumes: failed to post event {%v} on %v {%v}, err: {%v}",
+				ev.reason, ref.Kind, ref.Name, err)
+		}
+	}
+}
@@ -19,17 +19,18 @@ package driver
 import (
 	"fmt"
 	"net"
+	"os/exec"
+	"strings"
 	"time"
 
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/request"
-	"github.com/openebs/jiva-csi/pkg/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
 	"github.com/sirupsen/logrus"
-	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/util/mount"
 )
 
@@ -41,6 +42,32 @@ const (
 
 type Optfunc func(*NodeMounter)
 
+// inputExec is the mount.Exec which can also pass input to the stdin of
+// the command, secrets like the LUKS passphrase are passed this way so
+// that they never show up in the process list
+type inputExec interface {
+	mount.Exec
+	RunWithInput(input, cmd string, args ...string) ([]byte, error)
+}
+
+// osExec runs the commands on the host like the exec of mount.NewOsExec
+type osExec struct{}
+
+var _ inputExec = osExec{}
+
+// Run runs the command and returns its combined output
+func (osExec) Run(cmd string, args ...string) ([]byte, error) {
+	return exec.Command(cmd, args...).CombinedOutput()
+}
+
+// RunWithInput runs the command with the input on its stdin and returns
+// its combined output
+func (osExec) RunWithInput(input, cmd string, args ...string) ([]byte, error) {
+	c := exec.Command(cmd, args...)
+	c.Stdin = strings.NewReader(input)
+	return c.CombinedOutput()
+}
+
 // NodeMounter embeds the SafeFormatAndMount struct
 type NodeMounter struct {
 	mount.SafeFormatAndMount
@@ -51,7 +78,7 @@ type NodeMounter struct {
 func newNodeMounter() *NodeMounter {
 	nm := new(NodeMounter)
 	nm.Interface = mount.New("")
-	nm.Exec = mount.NewOsExec()
+	nm.Exec = osExec{}
 	return nm
 }
 
@@ -81,16 +108,16 @@ func (m *NodeMounter) GetDeviceName(mountPath string) (string, int, error) {
 }
 
 func doesVolumeExist(volID string, cli *client.Client) (*jv.JivaVolume, error) {
-	volID = utils.StripName(volID)
 	if err := cli.Set(); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// GetJivaVolume already returns gRPC errors
 	instance, err := cli.GetJivaVolume(volID)
-	if err != nil && errors.IsNotFound(err) {
-		return nil, status.Error(codes.NotFound, err.Error())
+	if status.Code(err) == codes.NotFound {
+		return nil, err
 	} else if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Error(codes.Internal, status.Convert(err).Message())
 	}
 	return instance, nil
 }
@@ -220,6 +247,12 @@ func (n *NodeMounter) MonitorMounts() {
 					vol.Spec.MountInfo.TargetPath == "" {
 					continue
 				}
+
+				// block volumes are not mounted at staging path and
+				// have no filesystem that can turn read-only
+				if isBlockVolume(&vol) {
+					continue
+				}
 				// Search the volume in the list of mounted volumes at the node
 				// retrieved above
 				stagingMountPoint, stagingPathExists := listContains(
@@ -239,8 +272,11 @@ func (n *NodeMounter) MonitorMounts() {
 					continue
 				}
 
-				if _, ok := request.TransitionVolList[vol.Name]; !ok {
-					request.TransitionVolList[vol.Name] = "Remount"
+				// node RPCs mark the volume in transition by its
+				// volume ID, which the CR name may be truncated from
+				volID := jivavolume.VolumeID(&vol)
+				if _, ok := request.TransitionVolList[volID]; !ok {
+					request.TransitionVolList[volID] = "Remount"
 					csivol := vol
 					go n.remount(csivol, stagingPathExists, targetPathExists)
 				}
@@ -265,7 +301,7 @@ func (n *NodeMounter) remount(vol jv.JivaVolume, stagingPathExists, targetPathEx
 		request.TransitionVolListLock.Lock()
 		// Remove the volume from ReqMountList once the remount operation is
 		// complete
-		delete(request.TransitionVolList, vol.Name)
+		delete(request.TransitionVolList, jivavolume.VolumeID(&vol))
 		request.TransitionVolListLock.Unlock()
 	}()
 
@@ -0,0 +1,131 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+// targetPortals returns the portals through which the volume is logged
+// in, the portal of the target service is always the first one. Multipath
+// volumes are also logged in through the target pod IPs and the additional
+// portals of the volume.
+func (ns *node) targetPortals(instance *jv.JivaVolume) []string {
+	port := fmt.Sprint(instance.Spec.ISCSISpec.TargetPort)
+	portals := []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, port)}
+	if !jivavolume.IsMultipath(instance) {
+		return portals
+	}
+
+	portals = append(portals, jivavolume.TargetPortals(instance)...)
+
+	// endpoints of the target service are the target pod IPs
+	eps, err := ns.client.GetEndpoints(instance.Name+"-jiva-ctrl-svc", instance.Namespace)
+	if err != nil {
+		logrus.Warningf("Failed to get target endpoints of volume {%v}, err: {%v}", instance.Name, err)
+	} else {
+		for _, subset := range eps.Subsets {
+			for _, addr := range subset.Addresses {
+				portals = append(portals, fmt.Sprintf("%v:%v", addr.IP, port))
+			}
+		}
+	}
+
+	var unique []string
+	seen := map[string]bool{}
+	for _, p := range portals {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
+
+// sessionPortals returns the portals of the iSCSI sessions logged in to the
+// target, the portals of a multipath volume may have changed since it was
+// logged in, so all of them are logged out
+func (ns *node) sessionPortals(iqn string) []string {
+	// iscsiadm fails if there are no sessions at all
+	out, err := ns.mounter.Exec.Run("iscsiadm", "-m", "session")
+	if err != nil {
+		return nil
+	}
+
+	// tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1 (non-flash)
+	var portals []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != iqn {
+			continue
+		}
+		portals = append(portals, strings.Split(fields[2], ",")[0])
+	}
+	return portals
+}
+
+// multipathDevice returns the multipath device holding the given device,
+// empty string is returned if the device is not a path of any
+func multipathDevice(devicePath string) string {
+	if strings.HasPrefix(filepath.Base(devicePath), "dm-") {
+		return devicePath
+	}
+
+	dev, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return ""
+	}
+
+	holders, err := ioutil.ReadDir(filepath.Join("/sys/block", filepath.Base(dev), "holders"))
+	if err != nil {
+		return ""
+	}
+
+	for _, h := range holders {
+		if strings.HasPrefix(h.Name(), "dm-") {
+			return filepath.Join("/dev", h.Name())
+		}
+	}
+	return ""
+}
+
+// flushMultipath removes the multipath devices holding the paths of the
+// volume before they are logged out, so that no stale map is left behind
+func (ns *node) flushMultipath(instance *jv.JivaVolume, portals []string) {
+	devices := map[string]bool{}
+	for _, p := range portals {
+		path := fmt.Sprintf("/dev/disk/by-path/ip-%v-iscsi-%v-lun-%v",
+			p, instance.Spec.ISCSISpec.Iqn, iscsiLUN(instance))
+		if dm := multipathDevice(path); dm != "" {
+			devices[dm] = true
+		}
+	}
+
+	for dm := range devices {
+		logrus.Infof("NodeUnstageVolume: flushing multipath device {%v}", dm)
+		if out, err := ns.mounter.Exec.Run("multipath", "-f", dm); err != nil {
+			logrus.Warningf("Failed to flush multipath device {%v}, err: {%v}, output: {%s}", dm, err, out)
+		}
+	}
+}
@@ -19,19 +19,20 @@ package driver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/request"
-	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
@@ -64,6 +65,7 @@ var (
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 )
 
@@ -71,6 +73,9 @@ type nodeStageRequest struct {
 	stagingPath string
 	fsType      string
 	volumeID    string
+	isBlock     bool
+	readOnly    bool
+	secrets     map[string]string
 }
 
 // node is the server implementation
@@ -91,17 +96,24 @@ func NewNode(d *CSIDriver, cli *client.Client) *node {
 	}
 }
 
-func (ns *node) attachDisk(instance *jv.JivaVolume) (string, error) {
+func (ns *node) attachDisk(instance *jv.JivaVolume, secrets map[string]string) (string, error) {
+	portals := ns.targetPortals(instance)
 	connector := iscsi.Connector{
 		VolumeName:    instance.Name,
 		TargetIqn:     instance.Spec.ISCSISpec.Iqn,
-		Lun:           defaultISCSILUN,
-		Interface:     defaultISCSIInterface,
-		TargetPortals: []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)},
+		Lun:           iscsiLUN(instance),
+		Interface:     iscsiInterface(instance),
+		TargetPortals: portals,
+		Multipath:     len(portals) > 1,
 		DoDiscovery:   true,
 	}
 
 	logrus.Debugf("NodeStageVolume: attach disk with config: {%+v}", connector)
+	setCHAPSecrets(&connector, secrets)
+	if err := ns.prepareNodeRecords(&connector, instance); err != nil {
+		return "", err
+	}
+
 	devicePath, err := iscsi.Connect(connector)
 	if err != nil {
 		return "", err
@@ -113,13 +125,27 @@ func (ns *node) attachDisk(instance *jv.JivaVolume) (string, error) {
 	return devicePath, err
 }
 
+// iscsiDevicePath returns the path of the device created by logging in to
+// the iSCSI target of the volume, multipath device is returned if the
+// paths are held by one
+func iscsiDevicePath(instance *jv.JivaVolume) string {
+	path := fmt.Sprintf("/dev/disk/by-path/ip-%v:%v-iscsi-%v-lun-%v",
+		instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort,
+		instance.Spec.ISCSISpec.Iqn, iscsiLUN(instance))
+	if jivavolume.IsMultipath(instance) {
+		if dm := multipathDevice(path); dm != "" {
+			return dm
+		}
+	}
+	return path
+}
+
 func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRequest, error) {
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
 
-	volID := utils.StripName(volumeID)
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume capability not provided")
@@ -129,14 +155,20 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
-	mount := volCap.GetMount()
-	if mount == nil {
-		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "NodeStageVolume: mount is nil within volume capability")
-	}
+	// block volumes are exposed as the raw device, so there is no
+	// filesystem to be created on them
+	var fsType string
+	isBlock := volCap.GetBlock() != nil
+	if !isBlock {
+		mount := volCap.GetMount()
+		if mount == nil {
+			return nodeStageRequest{}, status.Error(codes.InvalidArgument, "NodeStageVolume: mount is nil within volume capability")
+		}
 
-	fsType := mount.GetFsType()
-	if len(fsType) == 0 {
-		fsType = defaultFsType
+		fsType = mount.GetFsType()
+		if len(fsType) == 0 {
+			fsType = defaultFsType
+		}
 	}
 
 	stagingPath := req.GetStagingTargetPath()
@@ -144,10 +176,17 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "staging path is empty")
 	}
 
+	if err := jivavolume.ValidateCHAPSecrets(req.GetSecrets()); err != nil {
+		return nodeStageRequest{}, status.Errorf(codes.InvalidArgument, "Invalid CHAP secret, err: {%v}", err)
+	}
+
 	return nodeStageRequest{
-		volumeID:    volID,
+		volumeID:    volumeID,
 		fsType:      fsType,
 		stagingPath: stagingPath,
+		isBlock:     isBlock,
+		readOnly:    isReadOnlyMode(volCap),
+		secrets:     req.GetSecrets(),
 	}, nil
 }
 
@@ -179,8 +218,14 @@ func (ns *node) NodeStageVolume(
 		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
 
+	passphrase := reqParam.secrets[jivavolume.EncryptionPassphraseKey]
+	if jivavolume.IsEncrypted(instance) && passphrase == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Volume {%v} is encrypted, but %s is not provided in the secrets", reqParam.volumeID, jivavolume.EncryptionPassphraseKey)
+	}
+
 	// Volume may be mounted at targetPath (bind mount in NodePublish)
-	if err := ns.isAlreadyMounted(reqParam.volumeID, reqParam.stagingPath); err != nil {
+	if err := ns.isAlreadyMounted(jivavolume.VolumeName(instance), reqParam.stagingPath); err != nil {
 		return nil, err
 	}
 
@@ -194,12 +239,51 @@ func (ns *node) NodeStageVolume(
 			status.Error(codes.FailedPrecondition, err.Error())
 	}
 
-	devicePath, err := ns.attachDisk(instance)
+	devicePath, err := ns.attachDisk(instance, reqParam.secrets)
 	if err != nil {
 		logrus.Errorf("NodeStageVolume: failed to attachDisk for volume: {%v}, err: {%v}", reqParam.volumeID, err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err := os.MkdirAll(reqParam.stagingPath, 0750); err != nil {
+		logrus.Errorf("Failed to mkdir %s, error: %v", reqParam.stagingPath, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// encrypted volumes are staged through the opened LUKS device
+	if jivavolume.IsEncrypted(instance) {
+		devicePath, err = ns.openLUKS(instance, devicePath, passphrase, reqParam.readOnly)
+		if err != nil {
+			logrus.Errorf("NodeStageVolume: failed to open LUKS device of volume: {%v}, err: {%v}", reqParam.volumeID, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// Read-only volumes may be staged on many nodes at once, so the
+	// mount info of the node is not recorded on the JivaVolume CR, the
+	// stage is recorded under the annotation of read-only stages instead.
+	// This also keeps MonitorMounts from remounting them as rw.
+	if reqParam.readOnly {
+		if err := ns.recordReadOnlyStage(reqParam.volumeID, stageInfo{
+			StagingPath: reqParam.stagingPath,
+			DevicePath:  devicePath,
+		}); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to record read-only stage of volume {%v}, err: {%v}",
+				reqParam.volumeID, err)
+		}
+
+		if reqParam.isBlock {
+			logrus.Infof("NodeStageVolume: volume: {%v} is staged as read-only block device {%v}", reqParam.volumeID, devicePath)
+			return &csi.NodeStageVolumeResponse{}, nil
+		}
+
+		logrus.Infof("NodeStageVolume: start read-only mount operation on volume: {%v}", reqParam.volumeID)
+		if err := ns.mountReadOnly(req, devicePath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	// JivaVolume CR may be updated by jiva-operator
 	instance, err = ns.client.GetJivaVolume(reqParam.volumeID)
 	if err != nil {
@@ -214,9 +298,11 @@ func (ns *node) NodeStageVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := os.MkdirAll(reqParam.stagingPath, 0750); err != nil {
-		logrus.Errorf("Failed to mkdir %s, error: %v", reqParam.stagingPath, err)
-		return nil, status.Error(codes.Internal, err.Error())
+	// Device is published directly to the target path of
+	// block volumes, so nothing is mounted at staging path
+	if reqParam.isBlock {
+		logrus.Infof("NodeStageVolume: volume: {%v} is staged as block device {%v}", reqParam.volumeID, devicePath)
+		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
 	logrus.Infof("NodeStageVolume: start format and mount operation on volume: {%v}", reqParam.volumeID)
@@ -228,18 +314,7 @@ func (ns *node) NodeStageVolume(
 }
 
 func (ns *node) doesVolumeExist(volID string) (*jv.JivaVolume, error) {
-	volID = utils.StripName(volID)
-	if err := ns.client.Set(); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-
-	instance, err := ns.client.GetJivaVolume(volID)
-	if err != nil && errors.IsNotFound(err) {
-		return nil, status.Error(codes.NotFound, err.Error())
-	} else if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-	return instance, nil
+	return doesVolumeExist(volID, ns.client)
 }
 
 // NodeUnstageVolume unmounts the volume from
@@ -277,12 +352,26 @@ func (ns *node) NodeUnstageVolume(
 		return nil, status.Error(codes.Internal, msg)
 	}
 
-	// From the spec: If the volume corresponding to the volume_id
-	// is not staged to the staging_target_path, the Plugin MUST
-	// reply 0 OK.
+	// Block volumes are not mounted at the staging path, but the
+	// iSCSI session still needs to be logged out. Read-only stages
+	// may not have been recorded by the older versions, so the live
+	// sessions of the node are looked up as well.
 	if refCount == 0 {
-		logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
-		return &csi.NodeUnstageVolumeResponse{}, nil
+		instance, err := doesVolumeExist(volID, ns.client)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+
+		// From the spec: If the volume corresponding to the volume_id
+		// is not staged to the staging_target_path, the Plugin MUST
+		// reply 0 OK.
+		if instance == nil || !(isStagedAt(instance, target, ns.driver.config.NodeID) ||
+			isStagedReadOnlyAt(instance, target, ns.driver.config.NodeID) ||
+			len(ns.sessionPortals(instance.Spec.ISCSISpec.Iqn)) != 0) {
+			logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+		return ns.detachDisk(instance, target)
 	}
 
 	if refCount > 1 {
@@ -296,22 +385,58 @@ func (ns *node) NodeUnstageVolume(
 	}
 
 	instance, err := doesVolumeExist(volID, ns.client)
-	if err != nil {
+	if status.Code(err) == codes.NotFound {
+		// the iSCSI target of the volume is not known anymore
+		logrus.Warningf("NodeUnstageVolume: volume {%v} not found, skipping logout", volID)
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	} else if err != nil {
 		return nil, err
 	}
 
-	tgtIP := instance.Spec.ISCSISpec.TargetIP
-	logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}", tgtIP)
-	if err := iscsi.Disconnect(instance.Spec.ISCSISpec.Iqn, []string{fmt.Sprintf("%v:%v",
-		tgtIP, instance.Spec.ISCSISpec.TargetPort)}); err != nil {
+	return ns.detachDisk(instance, target)
+}
+
+// detachDisk logs out of the iSCSI target of the staged volume and
+// clears the staging details recorded on the JivaVolume CR
+func (ns *node) detachDisk(instance *jv.JivaVolume, stagingPath string) (*csi.NodeUnstageVolumeResponse, error) {
+	if jivavolume.IsEncrypted(instance) {
+		if err := ns.closeLUKS(instance); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// multipath volumes are logged in through many portals
+	iqn := instance.Spec.ISCSISpec.Iqn
+	portals := ns.sessionPortals(iqn)
+	if len(portals) == 0 {
+		portals = []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)}
+	}
+	if len(portals) > 1 {
+		ns.flushMultipath(instance, portals)
+	}
+
+	logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}, portals: {%v}", iqn, portals)
+	if err := iscsi.Disconnect(iqn, portals); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := os.RemoveAll(instance.Spec.MountInfo.StagingPath); err != nil {
+	if err := os.RemoveAll(stagingPath); err != nil {
 		logrus.Errorf("Failed to remove mount path, err: {%v}", err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err := ns.removeReadOnlyStage(jivavolume.VolumeID(instance), stagingPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to remove read-only stage of volume {%v}, err: {%v}",
+			instance.Name, err)
+	}
+
+	// mount info of read-only volumes is not recorded
+	// on the CR, it may belong to another node
+	if instance.Spec.MountInfo.StagingPath != stagingPath ||
+		instance.Labels["nodeID"] != ns.driver.config.NodeID {
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
 	// Setting to empty
 	instance.Spec.MountInfo.StagingPath = ""
 	instance.Labels["nodeID"] = ""
@@ -324,6 +449,34 @@ func (ns *node) NodeUnstageVolume(
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// mountReadOnly mounts the device at the staging path as read-only, the
+// device is expected to have a filesystem so it is never formatted
+func (ns *node) mountReadOnly(req *csi.NodeStageVolumeRequest, devicePath string) error {
+	mntPath := req.GetStagingTargetPath()
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(mntPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !notMnt {
+		logrus.Infof("Volume: {%s} has been mounted already at {%v}", req.GetVolumeId(), mntPath)
+		return nil
+	}
+
+	fsType := req.GetVolumeCapability().GetMount().GetFsType()
+	options := []string{"ro"}
+	options = append(options, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+
+	if err := ns.mounter.Mount(devicePath, mntPath, fsType, options); err != nil {
+		logrus.Errorf(
+			"Failed to mount iscsi volume {%s [%s, %s]} read-only to {%s}, error {%v}",
+			req.GetVolumeId(), devicePath, fsType, mntPath, err,
+		)
+		return err
+	}
+	return nil
+}
+
 func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath string) error {
 	// Mount device
 	mntPath := req.GetStagingTargetPath()
@@ -391,25 +544,33 @@ func (ns *node) NodePublishVolume(
 
 	defer request.RemoveVolumeFromTransitionList(volumeID)
 
+	instance, err := doesVolumeExist(volumeID, ns.client)
+	if err != nil {
+		return nil, err
+	}
+
 	// Volume may be mounted at targetPath (bind mount in NodePublish)
-	if err := ns.isAlreadyMounted(volumeID, target); err != nil {
+	if err := ns.isAlreadyMounted(jivavolume.VolumeName(instance), target); err != nil {
 		return nil, err
 	}
 
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	if req.GetReadonly() || isReadOnlyMode(volCap) {
 		mountOptions = append(mountOptions, "ro")
 	}
 	switch mode := volCap.GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		return &csi.NodePublishVolumeResponse{}, status.Error(codes.Unimplemented, "doesn't support block device provisioning")
+		if err := ns.nodePublishVolumeForBlock(req, mountOptions); err != nil {
+			return nil, err
+		}
 	case *csi.VolumeCapability_Mount:
 		if err := ns.nodePublishVolumeForFileSystem(req, mountOptions, mode); err != nil {
 			return nil, err
 		}
 	}
 
-	instance, err := doesVolumeExist(volumeID, ns.client)
+	// JivaVolume CR may be updated by jiva-operator
+	instance, err = doesVolumeExist(volumeID, ns.client)
 	if err != nil {
 		return nil, err
 	}
@@ -468,6 +629,53 @@ func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest
 	return nil
 }
 
+func (ns *node) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, mountOptions []string) error {
+	target := req.GetTargetPath()
+	instance, err := doesVolumeExist(req.GetVolumeId(), ns.client)
+	if err != nil {
+		return err
+	}
+
+	// device path of read-only volumes is not recorded on the CR as
+	// they can be staged on many nodes
+	source := instance.Spec.MountInfo.DevicePath
+	if isReadOnlyMode(req.GetVolumeCapability()) {
+		source = iscsiDevicePath(instance)
+		if jivavolume.IsEncrypted(instance) {
+			source = luksDevicePath(instance)
+		}
+	}
+
+	if source == "" {
+		return status.Errorf(codes.FailedPrecondition, "Device path of volume {%q} not found, volume may not be staged", req.GetVolumeId())
+	}
+
+	// Target path of the block volume is a file on which the
+	// device is bind mounted
+	targetDir := filepath.Dir(target)
+	logrus.Infof("NodePublishVolume: creating dir: {%s}", targetDir)
+	if err := os.MkdirAll(targetDir, 0750); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", targetDir, err)
+	}
+
+	logrus.Infof("NodePublishVolume: creating file: {%s}", target)
+	file, err := os.OpenFile(target, os.O_CREATE, 0660)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not create file {%q}, err: %v", target, err)
+	}
+	file.Close()
+
+	logrus.Infof("NodePublishVolume: start mounting: source: {%s} at target: {%s} with options: {%s}", source, target, mountOptions)
+	if err := ns.mounter.Mount(source, target, "", mountOptions); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, err)
+		}
+		return status.Errorf(codes.Internal, "Could not mount %q at %q: %v", source, target, err)
+	}
+
+	return nil
+}
+
 // NodeUnpublishVolume unpublishes (unmounts) the volume
 // from the corresponding node from the given path
 //
@@ -497,6 +705,14 @@ func (ns *node) NodeUnpublishVolume(
 		return nil, err
 	}
 
+	// file created as the target of block volume
+	// needs to be removed by the plugin
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not remove target %q: %v", target, err)
+		}
+	}
+
 	instance, err := doesVolumeExist(volumeID, ns.client)
 	if err != nil {
 		return nil, err
@@ -510,6 +726,8 @@ func (ns *node) NodeUnpublishVolume(
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// isAlreadyMounted checks the mounts of the volume by its name, which is a
+// part of the staging and target paths created by kubelet
 func (ns *node) isAlreadyMounted(volID, path string) error {
 	currentMounts := map[string]bool{}
 	mountList, err := ns.mounter.List()
@@ -551,6 +769,84 @@ func (ns *node) unmount(volumeID, target string) error {
 	return nil
 }
 
+// isBlockVolume checks if the volume is staged as a raw block device, no
+// filesystem type is recorded for such volumes while staging
+func isBlockVolume(instance *jv.JivaVolume) bool {
+	return instance.Spec.MountInfo.StagingPath != "" &&
+		instance.Spec.MountInfo.FSType == ""
+}
+
+// isStagedAt returns true if the volume is recorded on the CR as staged
+// as a block device at the staging path of the node
+func isStagedAt(instance *jv.JivaVolume, stagingPath, nodeID string) bool {
+	return isBlockVolume(instance) &&
+		instance.Spec.MountInfo.StagingPath == stagingPath &&
+		instance.Labels["nodeID"] == nodeID
+}
+
+// isStagedReadOnlyAt returns true if the volume is recorded on the CR as
+// staged read-only at the staging path of the node
+func isStagedReadOnlyAt(instance *jv.JivaVolume, stagingPath, nodeID string) bool {
+	stages, err := getReadOnlyStages(instance)
+	if err != nil {
+		logrus.Warningf("%v", err)
+		return false
+	}
+
+	stage, ok := stages[nodeID]
+	return ok && stage.StagingPath == stagingPath
+}
+
+// recordReadOnlyStage records the read-only stage of the volume on the
+// node, the stages of the other nodes may be recorded at the same time
+func (ns *node) recordReadOnlyStage(volumeID string, stage stageInfo) error {
+	return ns.updateReadOnlyStages(volumeID, func(stages map[string]stageInfo) bool {
+		if stages[ns.driver.config.NodeID] == stage {
+			return false
+		}
+		stages[ns.driver.config.NodeID] = stage
+		return true
+	})
+}
+
+// removeReadOnlyStage removes the read-only stage of the volume at the
+// staging path of the node, if it is recorded
+func (ns *node) removeReadOnlyStage(volumeID, stagingPath string) error {
+	return ns.updateReadOnlyStages(volumeID, func(stages map[string]stageInfo) bool {
+		stage, ok := stages[ns.driver.config.NodeID]
+		if !ok || stage.StagingPath != stagingPath {
+			return false
+		}
+		delete(stages, ns.driver.config.NodeID)
+		return true
+	})
+}
+
+// updateReadOnlyStages updates the read-only stages recorded on the
+// JivaVolume CR, the CR is updated only if update reports a change
+func (ns *node) updateReadOnlyStages(volumeID string, update func(map[string]stageInfo) bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := ns.client.GetJivaVolume(volumeID)
+		if err != nil {
+			return err
+		}
+
+		stages, err := getReadOnlyStages(instance)
+		if err != nil {
+			return err
+		}
+
+		if !update(stages) {
+			return nil
+		}
+
+		if err := setReadOnlyStages(instance, stages); err != nil {
+			return err
+		}
+		return ns.client.UpdateJivaVolume(instance)
+	})
+}
+
 // NodeGetInfo returns node details
 //
 // This implements csi.NodeServer
@@ -559,11 +855,48 @@ func (ns *node) NodeGetInfo(
 	req *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
 
+	topology, err := ns.nodeTopology()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeGetInfo: failed to get topology of node {%v}, err: {%v}", ns.driver.config.NodeID, err)
+	}
+
 	return &csi.NodeGetInfoResponse{
-		NodeId: ns.driver.config.NodeID,
+		NodeId:             ns.driver.config.NodeID,
+		AccessibleTopology: topology,
 	}, nil
 }
 
+// nodeTopology returns the topology segments of the node from the values of
+// the configured topology keys in the node labels
+func (ns *node) nodeTopology() (*csi.Topology, error) {
+	if len(ns.driver.config.TopologyKeys) == 0 {
+		return nil, nil
+	}
+
+	if err := ns.client.Set(); err != nil {
+		return nil, err
+	}
+
+	node, err := ns.client.GetNode(ns.driver.config.NodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := map[string]string{}
+	for _, key := range ns.driver.config.TopologyKeys {
+		if val, ok := node.Labels[key]; ok {
+			segments[key] = val
+		} else {
+			logrus.Warningf("NodeGetInfo: topology key {%v} not found in labels of node {%v}", key, node.Name)
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	return &csi.Topology{Segments: segments}, nil
+}
+
 // NodeGetCapabilities returns capabilities supported
 // by this node service
 //
@@ -638,8 +971,12 @@ func (ns *node) NodeExpandVolume(
 		fsType:       instance.Spec.MountInfo.FSType,
 		iqn:          instance.Spec.ISCSISpec.Iqn,
 		targetPortal: instance.Spec.ISCSISpec.TargetIP,
+		multipath:    jivavolume.IsMultipath(instance),
 		exec:         ns.mounter.Exec,
 	}
+	if jivavolume.IsEncrypted(instance) {
+		resize.luksName = luksMapperName(instance)
+	}
 
 	list, err := ns.mounter.List()
 	if err != nil {
@@ -688,8 +1025,18 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Errorf(codes.Internal, "Failed to retrieve capacity statistics for volume path {%q}: {%s}", volumePath, err)
 	}
 
+	// stats are still reported if the health of the volume
+	// can't be fetched
+	var condition *csi.VolumeCondition
+	if instance, err := ns.doesVolumeExist(volumeID); err != nil {
+		condition = abnormalCondition("failed to get status of volume: %v", err)
+	} else {
+		condition = volumeCondition(instance)
+	}
+
 	return &csi.NodeGetVolumeStatsResponse{
-		Usage: stats,
+		Usage:           stats,
+		VolumeCondition: condition,
 	}, nil
 }
 
@@ -0,0 +1,249 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// casTypeJiva is the only cas-type served by this driver
+	casTypeJiva = "jiva"
+
+	// reservedParameterPrefix is the prefix of the parameters added by
+	// the external-provisioner, they are not a part of the StorageClass
+	reservedParameterPrefix = "csi.storage.k8s.io/"
+)
+
+// parameterType is the type of value of a StorageClass parameter
+type parameterType int
+
+const (
+	// casTypeParameter must be the cas-type served by the driver
+	casTypeParameter parameterType = iota
+	// nameParameter is the name of a kubernetes resource
+	nameParameter
+	// namespaceParameter is the name of a kubernetes namespace
+	namespaceParameter
+	// positiveIntParameter is an integer greater than zero
+	positiveIntParameter
+	// boolParameter is either true or false
+	boolParameter
+	// quantityParameter is a kubernetes resource quantity
+	quantityParameter
+	// durationParameter is a positive duration like 72h
+	durationParameter
+	// portalListParameter is a comma separated list of ip[:port]
+	portalListParameter
+	// nonNegativeIntParameter is an integer greater than or equal to zero
+	nonNegativeIntParameter
+	// lunParameter is an iSCSI LUN
+	lunParameter
+	// ifaceParameter is the name of an iSCSI interface
+	ifaceParameter
+	// digestParameter is a comma separated list of None and CRC32C
+	digestParameter
+)
+
+// maxISCSILUN is the highest LUN addressable by the initiator
+const maxISCSILUN = 16383
+
+var ifaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._:-]*$`)
+
+// volumeParameters is the schema of the StorageClass parameters accepted
+// by the driver
+var volumeParameters = map[string]parameterType{
+	"cas-type":                         casTypeParameter,
+	"policy":                           nameParameter,
+	"namespace":                        namespaceParameter,
+	jivavolume.ReplicationFactorKey:    positiveIntParameter,
+	jivavolume.ReplicaSCKey:            nameParameter,
+	jivavolume.EnableBufioKey:          boolParameter,
+	jivavolume.TargetCPURequestKey:     quantityParameter,
+	jivavolume.TargetMemoryRequestKey:  quantityParameter,
+	jivavolume.ReplicaCPURequestKey:    quantityParameter,
+	jivavolume.ReplicaMemoryRequestKey: quantityParameter,
+	jivavolume.RetentionPeriodParam:    durationParameter,
+	jivavolume.MultipathParam:          boolParameter,
+	jivavolume.TargetPortalsParam:      portalListParameter,
+	jivavolume.EncryptedParam:          boolParameter,
+	jivavolume.ISCSIInterfaceParam:     ifaceParameter,
+	jivavolume.ISCSILUNParam:           lunParameter,
+	jivavolume.ReplacementTimeoutParam: nonNegativeIntParameter,
+	jivavolume.NoopOutIntervalParam:    nonNegativeIntParameter,
+	jivavolume.NoopOutTimeoutParam:     nonNegativeIntParameter,
+	jivavolume.HeaderDigestParam:       digestParameter,
+	jivavolume.DataDigestParam:         digestParameter,
+}
+
+// validateVolumeParameters verifies that the StorageClass parameters are
+// known to the driver and their values are well formed, so that a typo in
+// the StorageClass doesn't silently fall back to the defaults
+func validateVolumeParameters(params map[string]string) error {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, reservedParameterPrefix) {
+			continue
+		}
+
+		paramType, ok := volumeParameters[key]
+		if !ok {
+			return status.Errorf(codes.InvalidArgument,
+				"Invalid parameter {%v}, supported parameters are: {%v}", key, supportedParameters())
+		}
+
+		if err := validateParameter(paramType, params[key]); err != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"Invalid value {%v} of parameter {%v}, err: {%v}", params[key], key, err)
+		}
+	}
+	return nil
+}
+
+func validateParameter(paramType parameterType, val string) error {
+	switch paramType {
+	case casTypeParameter:
+		if val != casTypeJiva {
+			return fmt.Errorf("must be %q", casTypeJiva)
+		}
+	case nameParameter:
+		if errs := validation.IsDNS1123Subdomain(val); len(errs) != 0 {
+			return fmt.Errorf("%v", strings.Join(errs, ", "))
+		}
+	case namespaceParameter:
+		if errs := validation.IsDNS1123Label(val); len(errs) != 0 {
+			return fmt.Errorf("%v", strings.Join(errs, ", "))
+		}
+	case positiveIntParameter:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return fmt.Errorf("must be greater than zero")
+		}
+	case boolParameter:
+		if _, err := strconv.ParseBool(val); err != nil {
+			return err
+		}
+	case quantityParameter:
+		qty, err := resource.ParseQuantity(val)
+		if err != nil {
+			return err
+		}
+		if qty.Sign() < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+	case durationParameter:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("must be greater than zero")
+		}
+	case portalListParameter:
+		for _, p := range jivavolume.ParsePortals(val) {
+			host, port, err := net.SplitHostPort(p)
+			if err != nil {
+				return err
+			}
+			if net.ParseIP(host) == nil {
+				return fmt.Errorf("invalid IP {%v}", host)
+			}
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				return fmt.Errorf("invalid port {%v}", port)
+			}
+		}
+	case nonNegativeIntParameter:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+	case lunParameter:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > maxISCSILUN {
+			return fmt.Errorf("must be between 0 and %d", maxISCSILUN)
+		}
+	case ifaceParameter:
+		if !ifaceNameRegexp.MatchString(val) {
+			return fmt.Errorf("must match %v", ifaceNameRegexp)
+		}
+	case digestParameter:
+		for _, d := range strings.Split(val, ",") {
+			if d != "None" && d != "CRC32C" {
+				return fmt.Errorf("must be a comma separated list of None and CRC32C")
+			}
+		}
+	}
+	return nil
+}
+
+func supportedParameters() []string {
+	keys := make([]string, 0, len(volumeParameters))
+	for key := range volumeParameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateParameterRefs verifies that the JivaVolumePolicy and the replica
+// StorageClass referred by the parameters exist
+func (cs *controller) validateParameterRefs(params map[string]string) error {
+	policy, err := cs.client.GetVolumePolicySpec(params)
+	if err != nil && errors.IsNotFound(err) {
+		return status.Errorf(codes.InvalidArgument,
+			"JivaVolumePolicy {%v} not found, err: {%v}", params["policy"], err)
+	} else if err != nil {
+		return status.Errorf(codes.Internal,
+			"Failed to get JivaVolumePolicy {%v}, err: {%v}", params["policy"], err)
+	}
+
+	if _, err := cs.client.GetStorageClass(policy.ReplicaSC); err != nil && errors.IsNotFound(err) {
+		return status.Errorf(codes.InvalidArgument,
+			"Replica StorageClass {%v} not found, err: {%v}", policy.ReplicaSC, err)
+	} else if err != nil {
+		return status.Errorf(codes.Internal,
+			"Failed to get replica StorageClass {%v}, err: {%v}", policy.ReplicaSC, err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/cloud-provider/volume/helpers"
+)
+
+const (
+	// ReportPoolCapacityInterval is the time gap in seconds between two
+	// consecutive reports of the capacity of the replica pools on the node
+	ReportPoolCapacityInterval = 60
+
+	// poolCapacityRefresh is the age after which the reported capacity is
+	// reported again even if it hasn't changed, so that it doesn't expire
+	poolCapacityRefresh = 2 * ReportPoolCapacityInterval * time.Second
+
+	// poolCapacityAnnotation is the annotation on the node holding the
+	// capacity of the hostpath pools of the replica StorageClasses
+	poolCapacityAnnotation = "jiva.csi.openebs.io/pool-capacity"
+
+	// hostRootPath is where the base paths of the pools are mounted from
+	// the node in the node plugin, at the same paths under it
+	hostRootPath = "/host"
+
+	// localPVProvisioner is the provisioner of the openebs local PVs
+	// on which the replicas are placed
+	localPVProvisioner  = "openebs.io/local"
+	casConfigAnnotation = "cas.openebs.io/config"
+	defaultBasePath     = "/var/openebs/local"
+)
+
+// poolCapacity is the capacity of the filesystem backing the hostpath pool
+// of a replica StorageClass on a node
+type poolCapacity struct {
+	Total      int64     `json:"total"`
+	Available  int64     `json:"available"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+// casConfig is an entry of the cas.openebs.io/config annotation
+type casConfig struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// hostpathBasePath returns the base path of the hostpath pool backing the
+// StorageClass, false is returned if it is not a hostpath local PV class
+func hostpathBasePath(sc *storagev1.StorageClass) (string, bool) {
+	if sc.Provisioner != localPVProvisioner {
+		return "", false
+	}
+
+	var config []casConfig
+	if raw := sc.Annotations[casConfigAnnotation]; raw != "" {
+		dec := yaml.NewYAMLOrJSONDecoder(strings.NewReader(raw), len(raw))
+		if err := dec.Decode(&config); err != nil {
+			logrus.Warningf("Failed to parse %s of StorageClass {%v}, err: {%v}", casConfigAnnotation, sc.Name, err)
+			return "", false
+		}
+	}
+
+	storageType, basePath := "hostpath", defaultBasePath
+	for _, c := range config {
+		switch c.Name {
+		case "StorageType":
+			storageType = c.Value
+		case "BasePath":
+			basePath = c.Value
+		}
+	}
+	return basePath, storageType == "hostpath"
+}
+
+// statPool returns the capacity of the filesystem holding the base path,
+// only the base paths are mounted from the host under hostRootPath so a
+// path which is not a mount of its own isn't on the host filesystem
+func statPool(basePath string) (poolCapacity, error) {
+	path := filepath.Join(hostRootPath, basePath)
+	var root, stat unix.Stat_t
+	if err := unix.Stat(hostRootPath, &root); err != nil {
+		return poolCapacity{}, err
+	}
+
+	if err := unix.Stat(path, &stat); err != nil {
+		return poolCapacity{}, err
+	} else if stat.Dev == root.Dev {
+		return poolCapacity{}, fmt.Errorf("base path is not mounted in the node plugin under %s", hostRootPath)
+	}
+
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return poolCapacity{}, err
+	}
+
+	// volumes are provisioned in whole GiB, so the capacity is reported
+	// in GiB which also keeps the report from changing with every write
+	return poolCapacity{
+		Total:     roundDownToGiB(int64(statfs.Blocks) * int64(statfs.Bsize)),
+		Available: roundDownToGiB(int64(statfs.Bavail) * int64(statfs.Bsize)),
+	}, nil
+}
+
+func roundDownToGiB(size int64) int64 {
+	return size / helpers.GiB * helpers.GiB
+}
+
+// getPoolCapacity returns the capacities of the pools reported on the node
+func getPoolCapacity(node *corev1.Node) (map[string]poolCapacity, error) {
+	pools := map[string]poolCapacity{}
+	raw, ok := node.Annotations[poolCapacityAnnotation]
+	if !ok {
+		return pools, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &pools); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of node {%v}, err: {%v}", poolCapacityAnnotation, node.Name, err)
+	}
+	return pools, nil
+}
+
+// ReportPoolCapacity records the capacity of the hostpath pools of the
+// local PV StorageClasses on the node, GetCapacity of the controller
+// computes the capacity of the replica StorageClass from these.
+// This function runs a never ending loop therefore should be run as a goroutine
+func (ns *node) ReportPoolCapacity() {
+	logrus.Infof("Starting ReportPoolCapacity goroutine")
+	ticker := time.NewTicker(ReportPoolCapacityInterval * time.Second)
+	for ; true; <-ticker.C {
+		// reset the client to avoid caching issue
+		if err := ns.client.Set(); err != nil {
+			logrus.Warningf("ReportPoolCapacity: failed to set client, err: {%v}", err)
+			continue
+		}
+
+		if err := ns.reportPoolCapacity(); err != nil {
+			logrus.Warningf("ReportPoolCapacity: %v", err)
+		}
+	}
+}
+
+func (ns *node) reportPoolCapacity() error {
+	scs, err := ns.client.ListStorageClasses()
+	if err != nil {
+		return fmt.Errorf("failed to list StorageClasses, err: {%v}", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	pools := map[string]poolCapacity{}
+	for i := range scs.Items {
+		sc := &scs.Items[i]
+		basePath, ok := hostpathBasePath(sc)
+		if !ok {
+			continue
+		}
+
+		capacity, err := statPool(basePath)
+		if err != nil {
+			logrus.Warningf("ReportPoolCapacity: failed to stat base path {%v} of StorageClass {%v}, err: {%v}",
+				basePath, sc.Name, err)
+			continue
+		}
+		capacity.ReportedAt = now
+		pools[sc.Name] = capacity
+	}
+
+	node, err := ns.client.GetNode(ns.driver.config.NodeID)
+	if err != nil {
+		return err
+	}
+
+	reported, err := getPoolCapacity(node)
+	if err != nil {
+		logrus.Warningf("ReportPoolCapacity: %v", err)
+	} else if !poolsChanged(reported, pools, now) {
+		return nil
+	}
+
+	data, err := json.Marshal(pools)
+	if err != nil {
+		return err
+	}
+	return ns.client.PatchNodeAnnotation(node, poolCapacityAnnotation, string(data))
+}
+
+// poolsChanged checks if the capacity of the pools differs from the reported
+// one, or it is due to be reported again
+func poolsChanged(reported, pools map[string]poolCapacity, now time.Time) bool {
+	if len(reported) != len(pools) {
+		return true
+	}
+
+	for name, pool := range pools {
+		old, ok := reported[name]
+		if !ok || old.Total != pool.Total || old.Available != pool.Available ||
+			now.Sub(old.ReportedAt) >= poolCapacityRefresh {
+			return true
+		}
+	}
+	return false
+}
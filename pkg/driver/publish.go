@@ -0,0 +1,230 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+)
+
+const (
+	// publishAnnotation is the JivaVolume annotation under which the nodes
+	// on which the volume is published via ControllerPublishVolume are
+	// recorded
+	publishAnnotation = "openebs.io/published-nodes"
+
+	// readOnlyStageAnnotation is the JivaVolume annotation under which the
+	// nodes on which the volume is staged read-only are recorded, they
+	// may be many so the mount info and nodeID label are not used for them
+	readOnlyStageAnnotation = "openebs.io/read-only-stages"
+)
+
+// publishInfo is the access with which the volume is published on a node
+type publishInfo struct {
+	AccessMode string `json:"accessMode"`
+	Readonly   bool   `json:"readonly"`
+}
+
+// getPublishedNodes returns the nodes recorded on the JivaVolume CR on
+// which the volume is published
+func getPublishedNodes(instance *jv.JivaVolume) (map[string]publishInfo, error) {
+	nodes := map[string]publishInfo{}
+	val, ok := instance.Annotations[publishAnnotation]
+	if !ok || val == "" {
+		return nodes, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &nodes); err != nil {
+		return nil, fmt.Errorf("failed to decode published nodes of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	return nodes, nil
+}
+
+// setPublishedNodes records the given nodes on the JivaVolume CR, it
+// doesn't update the CR
+func setPublishedNodes(instance *jv.JivaVolume, nodes map[string]publishInfo) error {
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+
+	if len(nodes) == 0 {
+		delete(instance.Annotations, publishAnnotation)
+		return nil
+	}
+
+	val, err := json.Marshal(nodes)
+	if err != nil {
+		return fmt.Errorf("failed to encode published nodes of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	instance.Annotations[publishAnnotation] = string(val)
+	return nil
+}
+
+// stageInfo is where the volume is staged read-only on a node
+type stageInfo struct {
+	StagingPath string `json:"stagingPath"`
+	DevicePath  string `json:"devicePath"`
+}
+
+// getReadOnlyStages returns the nodes recorded on the JivaVolume CR on
+// which the volume is staged read-only
+func getReadOnlyStages(instance *jv.JivaVolume) (map[string]stageInfo, error) {
+	stages := map[string]stageInfo{}
+	val, ok := instance.Annotations[readOnlyStageAnnotation]
+	if !ok || val == "" {
+		return stages, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &stages); err != nil {
+		return nil, fmt.Errorf("failed to decode read-only stages of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	return stages, nil
+}
+
+// setReadOnlyStages records the given read-only stages on the JivaVolume
+// CR, it doesn't update the CR
+func setReadOnlyStages(instance *jv.JivaVolume, stages map[string]stageInfo) error {
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+
+	if len(stages) == 0 {
+		delete(instance.Annotations, readOnlyStageAnnotation)
+		return nil
+	}
+
+	val, err := json.Marshal(stages)
+	if err != nil {
+		return fmt.Errorf("failed to encode read-only stages of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	instance.Annotations[readOnlyStageAnnotation] = string(val)
+	return nil
+}
+
+// readOnlyNodeIDs returns the sorted IDs of the nodes on which the volume
+// is staged read-only
+func readOnlyNodeIDs(instance *jv.JivaVolume) ([]string, error) {
+	stages, err := getReadOnlyStages(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id := range stages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// publishedNodeIDs returns the sorted IDs of the nodes on which the volume
+// is published, volumes staged before being published through the
+// controller are reported with the node on which they are staged
+func publishedNodeIDs(instance *jv.JivaVolume) ([]string, error) {
+	nodes, err := getPublishedNodes(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+
+	// nodeID label is set by the node plugin on which
+	// the volume is staged
+	if nodeID := instance.Labels["nodeID"]; nodeID != "" && len(ids) == 0 {
+		ids = append(ids, nodeID)
+	}
+
+	if len(ids) == 0 {
+		if ids, err = readOnlyNodeIDs(instance); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// isVolumeInUse checks if the volume is still attached or staged on any
+// node, the reason is returned for the error message
+func isVolumeInUse(instance *jv.JivaVolume) (bool, string) {
+	if nodeID := instance.Labels["nodeID"]; nodeID != "" {
+		return true, fmt.Sprintf("staged on node {%v}", nodeID)
+	}
+
+	if path := instance.Spec.MountInfo.StagingPath; path != "" {
+		return true, fmt.Sprintf("staged at {%v}", path)
+	}
+
+	readOnly, err := readOnlyNodeIDs(instance)
+	if err != nil {
+		return true, err.Error()
+	}
+
+	if len(readOnly) != 0 {
+		return true, fmt.Sprintf("staged read-only on nodes {%v}", readOnly)
+	}
+
+	nodes, err := getPublishedNodes(instance)
+	if err != nil {
+		return true, err.Error()
+	}
+
+	if len(nodes) != 0 {
+		ids, _ := publishedNodeIDs(instance)
+		return true, fmt.Sprintf("published on nodes {%v}", ids)
+	}
+	return false, ""
+}
+
+// isForceDelete checks if the volume is annotated to be deleted even if it
+// is still in use on a node, this is meant for the volumes stuck on nodes
+// which are no longer available
+func isForceDelete(instance *jv.JivaVolume) bool {
+	force, _ := strconv.ParseBool(instance.Annotations[jivavolume.ForceDeleteKey])
+	return force
+}
+
+// isSingleNodeMode checks if the volume published with the given access
+// mode can only be accessed from one node at a time
+func isSingleNodeMode(mode string) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER.String(),
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY.String():
+		return true
+	}
+	return false
+}
+
+// isReadOnlyMode checks if the volume capability only allows read-only
+// access to the volume
+func isReadOnlyMode(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
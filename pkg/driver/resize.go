@@ -1,6 +1,9 @@
 package driver
 
 import (
+	"path/filepath"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 	"k8s.io/kubernetes/pkg/util/mount"
 )
@@ -10,6 +13,8 @@ type resizeInput struct {
 	fsType       string
 	iqn          string
 	targetPortal string
+	multipath    bool
+	luksName     string
 	exec         mount.Exec
 }
 
@@ -20,6 +25,23 @@ func (r resizeInput) volume(list []mount.MountPoint) error {
 			if err != nil {
 				return err
 			}
+			if r.multipath {
+				// LUKS device is set up on the multipath device
+				device := mpt.Device
+				if r.luksName != "" {
+					if device, err = luksBackingDevice(r.exec, r.luksName); err != nil {
+						return err
+					}
+				}
+				if err := r.resizeMultipath(device); err != nil {
+					return err
+				}
+			}
+			if r.luksName != "" {
+				if err := r.resizeLUKS(); err != nil {
+					return err
+				}
+			}
 			switch r.fsType {
 			case "ext4":
 				err = r.resizeExt4(mpt.Device)
@@ -35,10 +57,15 @@ func (r resizeInput) volume(list []mount.MountPoint) error {
 	return nil
 }
 
-// ReScan rescans all the iSCSI sessions on the host
+// ReScan rescans all the iSCSI sessions on the host, sessions through
+// every portal of the target are rescanned for multipath volumes
 func (r resizeInput) reScan() error {
 	logrus.Info("Rescan ISCSI session")
-	out, err := r.exec.Run("iscsiadm", "-m", "node", "-T", r.iqn, "-P", r.targetPortal, "--rescan")
+	args := []string{"-m", "node", "-T", r.iqn}
+	if !r.multipath {
+		args = append(args, "-P", r.targetPortal)
+	}
+	out, err := r.exec.Run("iscsiadm", append(args, "--rescan")...)
 	if err != nil {
 		logrus.Errorf("iscsi: rescan failed error: %s", string(out))
 		return err
@@ -46,6 +73,32 @@ func (r resizeInput) reScan() error {
 	return nil
 }
 
+// resizeMultipath resizes the multipath device to the size of its paths
+// once they are rescanned
+func (r resizeInput) resizeMultipath(device string) error {
+	if !strings.HasPrefix(device, "/dev/dm-") && !strings.HasPrefix(device, "/dev/mapper/") {
+		return nil
+	}
+
+	out, err := r.exec.Run("multipathd", "resize", "map", filepath.Base(device))
+	if err != nil {
+		logrus.Errorf("iscsi: multipath resize failed error: %s", string(out))
+		return err
+	}
+	return nil
+}
+
+// resizeLUKS resizes the opened LUKS device to the size of the device on
+// which it is set up, it must be done before the filesystem is grown
+func (r resizeInput) resizeLUKS() error {
+	out, err := r.exec.Run("cryptsetup", "resize", r.luksName)
+	if err != nil {
+		logrus.Errorf("iscsi: LUKS resize failed error: %s", string(out))
+		return err
+	}
+	return nil
+}
+
 // ResizeExt4 can be used to run a resize command on the ext4 filesystem
 // to expand the filesystem to the actual size of the device
 func (r resizeInput) resizeExt4(path string) error {
@@ -0,0 +1,205 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ReapVolumesInterval is the time gap in minutes between two
+	// consecutive runs of the reaper of the retained volumes
+	ReapVolumesInterval = 5
+
+	// deletedAtAnnotation is the time at which the retained volume
+	// was deleted
+	deletedAtAnnotation = "openebs.io/deleted-at"
+)
+
+// isRetained checks if the volume was provisioned with a retention period
+func isRetained(instance *jv.JivaVolume) bool {
+	_, ok := instance.Annotations[jivavolume.RetentionPeriodKey]
+	return ok
+}
+
+// isSoftDeleted checks if the volume has been deleted and is only being
+// retained until its retention period is over
+func isSoftDeleted(instance *jv.JivaVolume) bool {
+	return instance.Labels[jivavolume.SoftDeletedLabel] == "true"
+}
+
+// isRestoreRequested checks if the retained volume is annotated to be
+// restored as a new PV
+func isRestoreRequested(instance *jv.JivaVolume) bool {
+	restore, _ := strconv.ParseBool(instance.Annotations[jivavolume.RestoreKey])
+	return restore
+}
+
+// softDeleteVolume marks the volume as deleted with the time after which it
+// can be purged and scales down its target, so that the volume is no longer
+// served while its data is retained on the replicas
+func (cs *controller) softDeleteVolume(instance *jv.JivaVolume) error {
+	if !isSoftDeleted(instance) {
+		period, err := time.ParseDuration(instance.Annotations[jivavolume.RetentionPeriodKey])
+		if err != nil {
+			return fmt.Errorf("invalid retention period {%v}, err: {%v}",
+				instance.Annotations[jivavolume.RetentionPeriodKey], err)
+		}
+
+		now := time.Now().UTC()
+		if instance.Labels == nil {
+			instance.Labels = map[string]string{}
+		}
+		instance.Labels[jivavolume.SoftDeletedLabel] = "true"
+		instance.Annotations[deletedAtAnnotation] = now.Format(time.RFC3339)
+		instance.Annotations[jivavolume.PurgeAfterKey] = now.Add(period).Format(time.RFC3339)
+		if err := cs.client.UpdateJivaVolume(instance); err != nil {
+			return err
+		}
+	}
+
+	// jiva-operator only creates the target deployment if it is missing,
+	// so the replicas set here are left as they are
+	if err := cs.client.ScaleTarget(instance, 0); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to scale down target, err: {%v}", err)
+	}
+	return nil
+}
+
+// ReapVolumes purges the retained volumes whose retention period is over
+// and restores the ones which are requested to be restored.
+// This function runs a never ending loop therefore should be run as a goroutine
+func (cs *controller) ReapVolumes() {
+	logrus.Infof("Starting ReapVolumes goroutine")
+	ticker := time.NewTicker(ReapVolumesInterval * time.Minute)
+	for ; true; <-ticker.C {
+		// reset the client to avoid caching issue
+		if err := cs.client.Set(); err != nil {
+			logrus.Warningf("ReapVolumes: failed to set client, err: {%v}", err)
+			continue
+		}
+
+		list, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+			jivavolume.SoftDeletedLabel: "true",
+		})
+		if err != nil {
+			logrus.Warningf("ReapVolumes: failed to list retained volumes, err: {%v}", err)
+			continue
+		}
+
+		for i := range list.Items {
+			vol := &list.Items[i]
+			volumeID := jivavolume.VolumeID(vol)
+			if isRestoreRequested(vol) {
+				if err := cs.restoreVolume(vol); err != nil {
+					logrus.Errorf("ReapVolumes: failed to restore volume {%v}, err: {%v}", volumeID, err)
+				}
+				continue
+			}
+
+			purgeAfter, err := time.Parse(time.RFC3339, vol.Annotations[jivavolume.PurgeAfterKey])
+			if err != nil {
+				logrus.Warningf("ReapVolumes: invalid purge time of volume {%v}, err: {%v}", volumeID, err)
+				continue
+			}
+
+			if time.Now().Before(purgeAfter) {
+				continue
+			}
+
+			logrus.Infof("ReapVolumes: retention period of volume {%v} is over, purging it", volumeID)
+			if err := cs.client.DeleteJivaVolume(volumeID); err != nil {
+				logrus.Errorf("ReapVolumes: failed to purge volume {%v}, err: {%v}", volumeID, err)
+			}
+		}
+	}
+}
+
+// restoreVolume brings back the retained volume by scaling up its target
+// and creating a PV for it with the name, access modes, volume mode and
+// StorageClass of the deleted PV, the PV can be bound to a new PVC by
+// setting its claimRef or volumeName
+func (cs *controller) restoreVolume(instance *jv.JivaVolume) error {
+	size, err := resource.ParseQuantity(instance.Spec.Capacity)
+	if err != nil {
+		return fmt.Errorf("invalid capacity {%v}, err: {%v}", instance.Spec.Capacity, err)
+	}
+
+	volumeMode := jivavolume.PVVolumeMode(instance)
+	fsType := instance.Spec.MountInfo.FSType
+	if fsType == "" && volumeMode == corev1.PersistentVolumeFilesystem {
+		fsType = defaultFsType
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: jivavolume.VolumeName(instance),
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": cs.driver.config.DriverName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			},
+			AccessModes:                   jivavolume.PVAccessModes(instance),
+			VolumeMode:                    &volumeMode,
+			StorageClassName:              instance.Annotations[jivavolume.StorageClassKey],
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           cs.driver.config.DriverName,
+					VolumeHandle:     jivavolume.VolumeID(instance),
+					FSType:           fsType,
+					VolumeAttributes: volumeContext(instance),
+				},
+			},
+		},
+	}
+
+	if err := cs.client.CreatePersistentVolume(pv); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create PV {%v}, err: {%v}", pv.Name, err)
+		}
+		logrus.Warningf("ReapVolumes: PV {%v} already exists", pv.Name)
+	}
+
+	if err := cs.client.ScaleTarget(instance, 1); err != nil {
+		return fmt.Errorf("failed to scale up target, err: {%v}", err)
+	}
+
+	delete(instance.Labels, jivavolume.SoftDeletedLabel)
+	delete(instance.Annotations, deletedAtAnnotation)
+	delete(instance.Annotations, jivavolume.PurgeAfterKey)
+	delete(instance.Annotations, jivavolume.RestoreKey)
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return err
+	}
+
+	logrus.Infof("ReapVolumes: volume {%v} is restored as PV {%v}", jivavolume.VolumeID(instance), pv.Name)
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/util/retry"
+)
+
+// getSnapshotSource returns the recorded info of the snapshot from which
+// the volume is requested to be provisioned
+func (cs *controller) getSnapshotSource(snapID string) (snapshotInfo, error) {
+	volumeID, name, err := utils.ParseSnapshotID(snapID)
+	if err != nil {
+		return snapshotInfo{}, status.Errorf(codes.NotFound, "CreateVolume: source snapshot not found, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return snapshotInfo{}, err
+	}
+
+	snaps, err := getSnapshots(instance)
+	if err != nil {
+		return snapshotInfo{}, status.Error(codes.Internal, err.Error())
+	}
+
+	info, ok := snaps[name]
+	if !ok {
+		return snapshotInfo{}, status.Errorf(codes.NotFound, "CreateVolume: source snapshot {%v} not found", snapID)
+	}
+
+	if !info.ReadyToUse {
+		return snapshotInfo{}, status.Errorf(codes.Unavailable, "CreateVolume: source snapshot {%v} is not ready to use", snapID)
+	}
+	return info, nil
+}
+
+// prepareCloneSource validates the source volume of the clone and takes
+// the snapshot on it from which the replicas of the clone are seeded
+func (cs *controller) prepareCloneSource(ctx context.Context, req *csi.CreateVolumeRequest) error {
+	srcID := req.GetVolumeContentSource().GetVolume().GetVolumeId()
+	src, err := cs.client.GetJivaVolume(srcID)
+	if err != nil {
+		return err
+	}
+
+	srcSize, err := capacityBytes(src)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if reqSize := req.GetCapacityRange().GetRequiredBytes(); reqSize == 0 {
+		req.CapacityRange = &csi.CapacityRange{
+			RequiredBytes: srcSize,
+			LimitBytes:    req.GetCapacityRange().GetLimitBytes(),
+		}
+	} else if reqSize < srcSize {
+		return status.Errorf(codes.OutOfRange,
+			"CreateVolume: requested size {%v} is smaller than the source volume size {%v}", reqSize, srcSize)
+	}
+
+	// snapshot is taken only once, retries of the request will find
+	// the clone created already
+	_, err = cs.client.GetJivaVolume(client.VolumeID(req))
+	if err == nil {
+		return nil
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	if src.Status.Phase != jv.JivaVolumePhaseReady || src.Status.Status != "RW" {
+		return status.Errorf(codes.FailedPrecondition,
+			"CreateVolume: source volume {%v} is not ready, phase: {%v}, status: {%v}",
+			srcID, src.Status.Phase, src.Status.Status)
+	}
+
+	cli, err := cs.newJivaClient(src)
+	if err != nil {
+		return err
+	}
+
+	snapName := utils.CloneSnapshotName(req.GetName())
+	logrus.Infof("CreateVolume: creating snapshot {%v} of volume {%v} for clone", snapName, srcID)
+	if err := cli.Snapshot(ctx, snapName); err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: failed to snapshot source volume {%v}, err: {%v}", srcID, err)
+	}
+	return nil
+}
+
+// isVolumeSeeded checks if the replicas of the volume are seeded from the
+// content source, the seeded replicas register with the target only after
+// they have copied the data so the volume becomes RW after that
+func isVolumeSeeded(instance *jv.JivaVolume) (bool, error) {
+	if jivavolume.IsSeeded(instance) {
+		return true, nil
+	}
+
+	if instance.Status.Phase == jv.JivaVolumePhaseFailed {
+		return false, status.Errorf(codes.Internal, "CreateVolume: failed to seed volume {%v} from content source", instance.Name)
+	}
+	return instance.Status.Phase == jv.JivaVolumePhaseReady && instance.Status.Status == "RW", nil
+}
+
+// completeSeeding adopts the replicas which were created to seed the volume,
+// so that they are deleted along with it, deletes the snapshot taken on the
+// source volume of a clone and marks the volume seeded
+func (cs *controller) completeSeeding(ctx context.Context, instance *jv.JivaVolume) error {
+	if jivavolume.IsSeeded(instance) {
+		return nil
+	}
+
+	if err := cs.client.AdoptSeedReplicas(instance); err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: failed to adopt the replicas of volume {%v}, err: {%v}",
+			instance.Name, err)
+	}
+
+	if instance.Annotations[jivavolume.SourceTypeKey] == jivavolume.SourceTypeVolume {
+		if err := cs.deleteCloneSnapshot(ctx, instance); err != nil {
+			return err
+		}
+	}
+
+	// snapshot of a clone is deleted already, so the volume must be
+	// marked seeded even if it has been updated in the meanwhile
+	volumeID := jivavolume.VolumeID(instance)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instance, err := cs.client.GetJivaVolume(volumeID)
+		if err != nil {
+			return err
+		}
+
+		instance.Annotations[jivavolume.SeededKey] = "true"
+		return cs.client.UpdateJivaVolume(instance)
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: failed to mark volume {%v} seeded, err: {%v}",
+			instance.Name, err)
+	}
+
+	logrus.Infof("CreateVolume: volume {%v} is seeded from snapshot {%v} of volume {%v}", instance.Name,
+		instance.Annotations[jivavolume.SourceSnapshotKey], instance.Annotations[jivavolume.SourceVolumeKey])
+	return nil
+}
+
+// volumesSeededFrom returns the volumes which are still being seeded from
+// the given volume, only from the given snapshot of it if one is given
+func (cs *controller) volumesSeededFrom(src *jv.JivaVolume, snapshot string) ([]string, error) {
+	vols, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+		"openebs.io/component": "jiva-volume",
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list JivaVolumes, err: {%v}", err)
+	}
+
+	var seeding []string
+	for i := range vols.Items {
+		vol := &vols.Items[i]
+		if jivavolume.IsSeeded(vol) || vol.Annotations[jivavolume.SourceVolumeKey] != jivavolume.VolumeID(src) {
+			continue
+		}
+
+		if snapshot != "" && vol.Annotations[jivavolume.SourceSnapshotKey] != snapshot {
+			continue
+		}
+		seeding = append(seeding, jivavolume.VolumeID(vol))
+	}
+	return seeding, nil
+}
+
+// deleteCloneSnapshot deletes the snapshot taken on the source volume to
+// seed the clone, the replicas of the clone have their own copy of it once
+// they are seeded
+func (cs *controller) deleteCloneSnapshot(ctx context.Context, instance *jv.JivaVolume) error {
+	srcID := instance.Annotations[jivavolume.SourceVolumeKey]
+	snapName := instance.Annotations[jivavolume.SourceSnapshotKey]
+	src, err := cs.client.GetJivaVolume(srcID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Warningf("CreateVolume: source volume {%v} of clone {%v} not found, skip deleting snapshot {%v}",
+			srcID, instance.Name, snapName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cli, err := cs.newJivaClient(src)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("CreateVolume: deleting snapshot {%v} of volume {%v} taken for clone", snapName, srcID)
+	if err := cli.DeleteSnapshot(ctx, snapName); err != nil {
+		return status.Errorf(codes.Internal, "CreateVolume: failed to delete snapshot {%v} of volume {%v}, err: {%v}",
+			snapName, srcID, err)
+	}
+	return nil
+}
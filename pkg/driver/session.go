@@ -0,0 +1,139 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/jivavolume"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+)
+
+// iscsiNodeSettings maps the StorageClass parameters tuning the iSCSI
+// session to the settings of the node record
+var iscsiNodeSettings = map[string]string{
+	jivavolume.ReplacementTimeoutParam: "node.session.timeo.replacement_timeout",
+	jivavolume.NoopOutIntervalParam:    "node.conn[0].timeo.noop_out_interval",
+	jivavolume.NoopOutTimeoutParam:     "node.conn[0].timeo.noop_out_timeout",
+	jivavolume.HeaderDigestParam:       "node.conn[0].iscsi.HeaderDigest",
+	jivavolume.DataDigestParam:         "node.conn[0].iscsi.DataDigest",
+}
+
+// iscsiInterface returns the iSCSI interface through which the volume is
+// logged in
+func iscsiInterface(instance *jv.JivaVolume) string {
+	if iface := jivavolume.ISCSIOptions(instance)[jivavolume.ISCSIInterfaceParam]; iface != "" {
+		return iface
+	}
+	return defaultISCSIInterface
+}
+
+// iscsiLUN returns the LUN of the volume on the target
+func iscsiLUN(instance *jv.JivaVolume) int32 {
+	lun, err := strconv.ParseInt(jivavolume.ISCSIOptions(instance)[jivavolume.ISCSILUNParam], 10, 32)
+	if err != nil {
+		return defaultISCSILUN
+	}
+	return int32(lun)
+}
+
+// nodeSettings returns the iscsiadm arguments which apply the session
+// options of the volume to its node records
+func nodeSettings(instance *jv.JivaVolume) []string {
+	opts := jivavolume.ISCSIOptions(instance)
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		if _, ok := iscsiNodeSettings[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, key := range keys {
+		args = append(args, "-n", iscsiNodeSettings[key], "-v", opts[key])
+	}
+	return args
+}
+
+// sessionCHAPSettings returns the iscsiadm arguments which set the session
+// CHAP credentials on the node records
+func sessionCHAPSettings(secrets iscsi.Secrets) []string {
+	if secrets.SecretsType != chapSecretsType {
+		return nil
+	}
+
+	args := []string{
+		"-n", "node.session.auth.authmethod", "-v", "CHAP",
+		"-n", jivavolume.SessionCHAPUsernameKey, "-v", secrets.UserName,
+		"-n", jivavolume.SessionCHAPPasswordKey, "-v", secrets.Password,
+	}
+	if secrets.UserNameIn != "" {
+		args = append(args, "-n", jivavolume.SessionCHAPUsernameInKey, "-v", secrets.UserNameIn)
+	}
+	if secrets.PasswordIn != "" {
+		args = append(args, "-n", jivavolume.SessionCHAPPasswordInKey, "-v", secrets.PasswordIn)
+	}
+	return args
+}
+
+// prepareNodeRecords discovers the target through the portals which are
+// not logged in yet and applies the session options and CHAP credentials
+// to their node records, so that they take effect on login. The connector
+// is left to only log in, as discovering again would reset the records.
+func (ns *node) prepareNodeRecords(connector *iscsi.Connector, instance *jv.JivaVolume) error {
+	loggedIn := map[string]bool{}
+	for _, p := range ns.sessionPortals(connector.TargetIqn) {
+		loggedIn[p] = true
+	}
+
+	settings := append(nodeSettings(instance), sessionCHAPSettings(connector.SessionSecrets)...)
+	for _, p := range connector.TargetPortals {
+		if loggedIn[p] {
+			continue
+		}
+
+		if connector.DoDiscovery {
+			if err := iscsi.Discovery(p, connector.Interface, connector.DiscoverySecrets, connector.DoCHAPDiscovery); err != nil {
+				// login through this portal fails as well,
+				// which is reported by connect
+				logrus.Warningf("NodeStageVolume: failed to discover target {%v} through portal {%v}, err: {%v}",
+					connector.TargetIqn, p, err)
+				continue
+			}
+		}
+
+		if len(settings) == 0 {
+			continue
+		}
+
+		// arguments hold the CHAP secrets so they must not be logged
+		args := append([]string{"-m", "node", "-T", connector.TargetIqn, "-p", p,
+			"-I", connector.Interface, "-o", "update"}, settings...)
+		if out, err := ns.mounter.Exec.Run("iscsiadm", args...); err != nil {
+			return fmt.Errorf("failed to update node record of portal {%v}, err: {%v}, output: {%s}", p, err, out)
+		}
+	}
+
+	connector.DoDiscovery = false
+	connector.DoCHAPDiscovery = false
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// snapshotAnnotation is the JivaVolume annotation under which the
+	// snapshots taken via CSI are recorded, jiva itself doesn't keep any
+	// metadata (creation time, size) about the snapshots
+	snapshotAnnotation = "openebs.io/snapshots"
+)
+
+// snapshotInfo is the metadata of a snapshot recorded on the JivaVolume CR
+type snapshotInfo struct {
+	CreationTime time.Time `json:"creationTime"`
+	SizeBytes    int64     `json:"sizeBytes"`
+	ReadyToUse   bool      `json:"readyToUse"`
+}
+
+// getSnapshots returns the snapshots recorded on the JivaVolume CR
+func getSnapshots(instance *jv.JivaVolume) (map[string]snapshotInfo, error) {
+	snaps := map[string]snapshotInfo{}
+	val, ok := instance.Annotations[snapshotAnnotation]
+	if !ok || val == "" {
+		return snaps, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &snaps); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshots of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	return snaps, nil
+}
+
+// setSnapshots records the given snapshots on the JivaVolume CR, it doesn't
+// update the CR
+func setSnapshots(instance *jv.JivaVolume, snaps map[string]snapshotInfo) error {
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+
+	if len(snaps) == 0 {
+		delete(instance.Annotations, snapshotAnnotation)
+		return nil
+	}
+
+	val, err := json.Marshal(snaps)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshots of JivaVolume: {%v}, err: {%v}", instance.Name, err)
+	}
+	instance.Annotations[snapshotAnnotation] = string(val)
+	return nil
+}
+
+// capacityBytes returns the size of JivaVolume in bytes
+func capacityBytes(instance *jv.JivaVolume) (int64, error) {
+	size, err := resource.ParseQuantity(instance.Spec.Capacity)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse capacity: {%v} of JivaVolume: {%v}, err: {%v}",
+			instance.Spec.Capacity, instance.Name, err)
+	}
+	return size.Value(), nil
+}
+
+// newCSISnapshot converts the recorded snapshot into CSI snapshot
+func newCSISnapshot(volumeID, name string, info snapshotInfo) (*csi.Snapshot, error) {
+	ts, err := ptypes.TimestampProto(info.CreationTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.Snapshot{
+		SnapshotId:     utils.SnapshotID(volumeID, name),
+		SourceVolumeId: volumeID,
+		SizeBytes:      info.SizeBytes,
+		CreationTime:   ts,
+		ReadyToUse:     info.ReadyToUse,
+	}, nil
+}
+
+// sortSnapshots sorts the snapshot entries by ID so that the pagination
+// tokens remain valid across ListSnapshots calls
+func sortSnapshots(entries []*csi.ListSnapshotsResponse_Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Snapshot.SnapshotId < entries[j].Snapshot.SnapshotId
+	})
+}
+
+// newJivaClient returns the REST client of the jiva controller serving the
+// given volume
+func (cs *controller) newJivaClient(instance *jv.JivaVolume) (jiva.Interface, error) {
+	ctrlIP := instance.Spec.ISCSISpec.TargetIP
+	if len(ctrlIP) == 0 {
+		return nil, status.Errorf(codes.Internal, "Target IP is nil")
+	}
+
+	config := jiva.DefaultConfig()
+	if port := cs.driver.config.JivaPort; port != 0 {
+		config.Port = port
+	}
+	if timeout := cs.driver.config.JivaTimeout; timeout != 0 {
+		config.Timeout = timeout
+	}
+	return cs.jivaClient(ctrlIP, config), nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort is the port on which the jiva controller serves its
+	// REST API
+	DefaultPort = 9501
+
+	// DefaultTimeout is the timeout of a single request to the jiva
+	// controller
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultRetries is the number of times a failed request is retried
+	DefaultRetries = 5
+)
+
+// Config is the configuration of the jiva controller client
+type Config struct {
+	// Port of the jiva controller REST API
+	Port int
+
+	// Timeout of a single request
+	Timeout time.Duration
+
+	// Retries is the number of times a failed request is retried, the
+	// requests are retried with exponential backoff and jitter starting
+	// at InitialBackoff till MaxBackoff. Only the idempotent requests,
+	// like resize, are retried on any failure. The other actions are
+	// retried only if they fail before they are sent.
+	Retries        int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultConfig returns the default configuration of the client
+func DefaultConfig() Config {
+	return Config{
+		Port:           DefaultPort,
+		Timeout:        DefaultTimeout,
+		Retries:        DefaultRetries,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+	}
+}
+
+// Client is the REST client of a jiva controller
+type Client struct {
+	address    string
+	config     Config
+	httpClient *http.Client
+}
+
+// rnd is the source of the jitter of the backoff, rand.Rand is not safe
+// for concurrent use so it is guarded by rndMu
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// HTTPError is returned when the jiva controller responds with an error
+// status code
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("bad response: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// NewClient returns the client of the jiva controller serving at the given
+// IP, host or URL
+func NewClient(host string, config Config) *Client {
+	address := host
+	if !strings.HasPrefix(address, "http") {
+		if config.Port != 0 {
+			address = net.JoinHostPort(address, strconv.Itoa(config.Port))
+		}
+		address = "http://" + address
+	}
+	address = strings.TrimSuffix(address, "/")
+	if !strings.HasSuffix(address, "/v1") {
+		address += "/v1"
+	}
+
+	return &Client{
+		address:    address,
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// GetVolume returns the volume served by the jiva controller
+func (c *Client) GetVolume(ctx context.Context) (*Volume, error) {
+	vols := volumeCollection{}
+	if err := c.do(ctx, http.MethodGet, "/volumes", nil, &vols, true); err != nil {
+		return nil, err
+	}
+
+	if len(vols.Data) == 0 {
+		return nil, fmt.Errorf("no volume found")
+	}
+	return &vols.Data[0], nil
+}
+
+// Resize resizes the volume to the given size, resizing to the size
+// already in place is a no-op so it is retried like the other idempotent
+// requests
+func (c *Client) Resize(ctx context.Context, size string) error {
+	vol, err := c.GetVolume(ctx)
+	if err != nil {
+		return err
+	}
+	return c.action(ctx, vol, "resize", resizeInput{Name: vol.Name, Size: size}, true)
+}
+
+// Snapshot takes a snapshot of the volume with the given name
+func (c *Client) Snapshot(ctx context.Context, name string) error {
+	vol, err := c.GetVolume(ctx)
+	if err != nil {
+		return err
+	}
+	return c.action(ctx, vol, "snapshot", snapshotInput{Name: name}, false)
+}
+
+// DeleteSnapshot deletes the snapshot of the volume
+func (c *Client) DeleteSnapshot(ctx context.Context, name string) error {
+	vol, err := c.GetVolume(ctx)
+	if err != nil {
+		return err
+	}
+	return c.action(ctx, vol, "deleteSnapshot", snapshotInput{Name: name}, false)
+}
+
+// ListReplicas returns the replicas connected to the jiva controller
+func (c *Client) ListReplicas(ctx context.Context) ([]Replica, error) {
+	reps := replicaCollection{}
+	if err := c.do(ctx, http.MethodGet, "/replicas", nil, &reps, true); err != nil {
+		return nil, err
+	}
+	return reps.Data, nil
+}
+
+// action posts the input to the given action of the volume, idempotent
+// actions are retried like the other idempotent requests
+func (c *Client) action(ctx context.Context, vol *Volume, action string, input interface{}, idempotent bool) error {
+	path, ok := vol.Actions[action]
+	if !ok {
+		return fmt.Errorf("action {%v} is not supported by jiva controller", action)
+	}
+	return c.do(ctx, http.MethodPost, path, input, nil, idempotent)
+}
+
+// do sends the request, retrying idempotent ones on failures other than
+// client errors and the others only if they couldn't be sent, and decodes
+// the response body into out if it is not nil
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, idempotent bool) error {
+	url := path
+	if !strings.HasPrefix(url, "http") {
+		url = c.address + path
+	}
+
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.config.InitialBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.send(ctx, method, url, body, out)
+		if err == nil || !isRetryable(err, idempotent) || attempt >= c.config.Retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s: %v, last err: %v", method, url, ctx.Err(), err)
+		case <-time.After(jitter(backoff)):
+		}
+
+		backoff *= 2
+		if c.config.MaxBackoff > 0 && backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", method, url, err)
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(content)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// isRetryable checks if the failed request may succeed if it is retried,
+// client errors are not retried. A request which isn't idempotent, like
+// snapshot, may have been applied even if its response is lost, so it is
+// retried only if the connection to the jiva controller couldn't be made.
+func isRetryable(err error, idempotent bool) bool {
+	if httpErr, ok := err.(*HTTPError); ok {
+		return idempotent && httpErr.StatusCode >= 500
+	}
+	return idempotent || isDialError(err)
+}
+
+// isDialError checks if the request failed while connecting to the jiva
+// controller, i.e before anything was sent
+func isDialError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// jitter returns a random duration between d and 1.5 * d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return d + time.Duration(rnd.Int63n(int64(d)/2+1))
+}
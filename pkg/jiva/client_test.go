@@ -0,0 +1,256 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig retries quickly so that the tests don't take long
+func testConfig(retries int) Config {
+	return Config{
+		Timeout:        time.Second,
+		Retries:        retries,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+	}
+}
+
+// volumeHandler serves the volume of the jiva controller, the requests are
+// failed with the given status codes first
+func volumeHandler(t *testing.T, calls *int32, failures ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n <= len(failures) {
+			http.Error(w, "failed", failures[n-1])
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/volumes":
+			fmt.Fprintf(w, `{"data": [{"name": "vol1", "actions": {"resize": "http://%s/v1/volumes/vol1?action=resize"}}]}`, r.Host)
+		case "/v1/volumes/vol1":
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestGetVolumeRetriesServerErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(volumeHandler(t, &calls, http.StatusInternalServerError, http.StatusServiceUnavailable))
+	defer srv.Close()
+
+	vol, err := NewClient(srv.URL, testConfig(3)).GetVolume(context.Background())
+	if err != nil {
+		t.Fatalf("GetVolume failed: %v", err)
+	}
+	if vol.Name != "vol1" {
+		t.Errorf("got volume %q, want vol1", vol.Name)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestGetVolumeStopsAfterRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(volumeHandler(t, &calls,
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, testConfig(2)).GetVolume(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got error %v, want the last server error", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestGetVolumeDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(volumeHandler(t, &calls, http.StatusNotFound))
+	defer srv.Close()
+
+	if _, err := NewClient(srv.URL, testConfig(3)).GetVolume(context.Background()); err == nil {
+		t.Error("GetVolume succeeded, want the client error")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+// actionHandler serves the volume of the jiva controller and fails the
+// posted actions with the given status code, counting them in posts
+func actionHandler(posts *int32, code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if atomic.AddInt32(posts, 1) == 1 {
+				http.Error(w, "failed", code)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		fmt.Fprintf(w, `{"data": [{"name": "vol1", "actions": {`+
+			`"resize": "http://%[1]s/v1/volumes/vol1?action=resize", `+
+			`"snapshot": "http://%[1]s/v1/volumes/vol1?action=snapshot"}}]}`, r.Host)
+	}
+}
+
+func TestResizeIsRetried(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(actionHandler(&posts, http.StatusInternalServerError))
+	defer srv.Close()
+
+	if err := NewClient(srv.URL, testConfig(3)).Resize(context.Background(), "10Gi"); err != nil {
+		t.Errorf("Resize failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 2 {
+		t.Errorf("got %d posts, want 2", n)
+	}
+}
+
+func TestSnapshotIsNotRetried(t *testing.T) {
+	var posts int32
+	srv := httptest.NewServer(actionHandler(&posts, http.StatusInternalServerError))
+	defer srv.Close()
+
+	if err := NewClient(srv.URL, testConfig(3)).Snapshot(context.Background(), "snap1"); err == nil {
+		t.Error("Snapshot succeeded, want the server error")
+	}
+	if n := atomic.LoadInt32(&posts); n != 1 {
+		t.Errorf("got %d posts, want 1", n)
+	}
+}
+
+func TestDialErrorsAreRetryable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := http.Post(srv.URL, "application/json", nil)
+	if err == nil {
+		t.Fatal("post to the closed server succeeded")
+	}
+	if !isRetryable(err, false) {
+		t.Errorf("dial error %v is not retryable", err)
+	}
+	if isRetryable(&HTTPError{StatusCode: http.StatusInternalServerError}, false) {
+		t.Error("server error of a non idempotent request is retryable")
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(volumeHandler(t, &calls,
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError))
+	defer srv.Close()
+
+	config := testConfig(3)
+	config.InitialBackoff = 50 * time.Millisecond
+	config.MaxBackoff = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := NewClient(srv.URL, config).GetVolume(context.Background()); err != nil {
+		t.Fatalf("GetVolume failed: %v", err)
+	}
+
+	// 3 backoffs of 50ms with up to 50% jitter, they would take at
+	// least 350ms if the backoff wasn't capped
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("retries took %v, want between 150ms and 300ms", elapsed)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 100 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		if j := jitter(d); j < d || j > d+d/2 {
+			t.Fatalf("jitter(%v) = %v, want between %v and %v", d, j, d, d+d/2)
+		}
+	}
+	if j := jitter(0); j != 0 {
+		t.Errorf("jitter(0) = %v, want 0", j)
+	}
+}
+
+func TestContextCancelledDuringBackoff(t *testing.T) {
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		cancel()
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	config := testConfig(3)
+	config.InitialBackoff = time.Hour
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewClient(srv.URL, config).GetVolume(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetVolume didn't return after the context was cancelled")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestContextCancelledDuringRequest(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	config := testConfig(3)
+	config.Timeout = time.Minute
+
+	start := time.Now()
+	if _, err := NewClient(srv.URL, config).GetVolume(ctx); err == nil {
+		t.Error("GetVolume succeeded, want the context error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetVolume returned after %v, want it to return once the context is done", elapsed)
+	}
+}
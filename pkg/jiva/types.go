@@ -0,0 +1,78 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import "context"
+
+// Interface is the REST API of the jiva controller used by the driver
+type Interface interface {
+	// GetVolume returns the volume served by the jiva controller
+	GetVolume(ctx context.Context) (*Volume, error)
+
+	// Resize resizes the volume to the given size, like 10Gi
+	Resize(ctx context.Context, size string) error
+
+	// Snapshot takes a snapshot of the volume with the given name
+	Snapshot(ctx context.Context, name string) error
+
+	// DeleteSnapshot deletes the snapshot of the volume
+	DeleteSnapshot(ctx context.Context, name string) error
+
+	// ListReplicas returns the replicas connected to the jiva controller
+	ListReplicas(ctx context.Context) ([]Replica, error)
+}
+
+// Resource keeps the links and actions of a REST resource
+type Resource struct {
+	ID      string            `json:"id,omitempty"`
+	Type    string            `json:"type,omitempty"`
+	Links   map[string]string `json:"links,omitempty"`
+	Actions map[string]string `json:"actions,omitempty"`
+}
+
+// Volume is the volume served by the jiva controller
+type Volume struct {
+	Resource
+	Name         string `json:"name"`
+	ReplicaCount int    `json:"replicaCount"`
+	ReadOnly     string `json:"readOnly"`
+}
+
+// Replica is a replica connected to the jiva controller
+type Replica struct {
+	Resource
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+}
+
+type volumeCollection struct {
+	Data []Volume `json:"data"`
+}
+
+type replicaCollection struct {
+	Data []Replica `json:"data"`
+}
+
+type resizeInput struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+type snapshotInput struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
@@ -17,12 +17,263 @@ limitations under the License.
 package jivavolume
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
+const (
+	// SourceTypeKey is the annotation key of the type of content source
+	// (snapshot or volume) from which the JivaVolume data is seeded
+	SourceTypeKey = "openebs.io/source-type"
+	// SourceTypeSnapshot is the content source type of volumes restored
+	// from a snapshot
+	SourceTypeSnapshot = "snapshot"
+	// SourceTypeVolume is the content source type of volumes cloned from
+	// another volume
+	SourceTypeVolume = "volume"
+	// SourceVolumeKey is the annotation key of the volume from which the
+	// JivaVolume data is seeded
+	SourceVolumeKey = "openebs.io/source-volume"
+	// SourceSnapshotKey is the annotation key of the snapshot of the
+	// source volume from which the JivaVolume data is seeded
+	SourceSnapshotKey = "openebs.io/source-snapshot"
+	// SeededKey is the annotation key which marks the JivaVolume as
+	// seeded from its content source
+	SeededKey = "openebs.io/seeded"
+	// VolumeIDKey is the annotation key of the CSI volume ID of the
+	// JivaVolume
+	VolumeIDKey = "openebs.io/volume-id"
+	// VolumeNameKey is the annotation key of the name of the volume
+	// requested by the CO, name of the CR may be a truncated form of it
+	VolumeNameKey = "openebs.io/volume-name"
+	// ParametersKey is the annotation key of the StorageClass parameters
+	// with which the JivaVolume was provisioned
+	ParametersKey = "openebs.io/parameters"
+	// ForceDeleteKey is the annotation key which allows the JivaVolume
+	// to be deleted while it is still staged or published on a node
+	ForceDeleteKey = "openebs.io/force-delete"
+	// RetentionPeriodKey is the annotation key of the duration for which
+	// the JivaVolume is retained after the volume is deleted
+	RetentionPeriodKey = "openebs.io/retention-period"
+	// PurgeAfterKey is the annotation key of the time after which the
+	// retained JivaVolume is purged
+	PurgeAfterKey = "openebs.io/purge-after"
+	// RestoreKey is the annotation key which requests the retained
+	// JivaVolume to be restored as a new PV
+	RestoreKey = "openebs.io/restore"
+	// SoftDeletedLabel is the label key of the JivaVolumes which are
+	// retained after the volume is deleted
+	SoftDeletedLabel = "openebs.io/soft-deleted"
+	// AccessModesKey is the annotation key of the access modes of the PV
+	// of the retained volume, the restored PV gets the same ones
+	AccessModesKey = "openebs.io/access-modes"
+	// VolumeModeKey is the annotation key of the volume mode of the PV
+	// of the retained volume
+	VolumeModeKey = "openebs.io/volume-mode"
+	// StorageClassKey is the annotation key of the StorageClass of the
+	// PVC of the retained volume
+	StorageClassKey = "openebs.io/storage-class"
+	// CHAPSecretKey is the annotation key of the secret holding the CHAP
+	// credentials with which the target authenticates the initiators
+	CHAPSecretKey = "openebs.io/chap-secret"
+	// MultipathKey is the annotation key which enables logging in to the
+	// volume through multiple target portals
+	MultipathKey = "openebs.io/multipath"
+	// TargetPortalsKey is the annotation key of the additional target
+	// portals through which the volume is logged in
+	TargetPortalsKey = "openebs.io/target-portals"
+	// EncryptedKey is the annotation key which enables LUKS encryption of
+	// the volume on the nodes
+	EncryptedKey = "openebs.io/encrypted"
+	// RekeySecretKey is the annotation key of the secret holding the new
+	// LUKS passphrase of the volume, the secret must be in the namespace
+	// of the JivaVolume
+	RekeySecretKey = "openebs.io/rekey-secret"
+	// RekeyedAtKey is the annotation key of the time at which the LUKS
+	// passphrase of the volume was last changed
+	RekeyedAtKey = "openebs.io/rekeyed-at"
+	// ISCSIOptionPrefix is the prefix of the annotation keys of the iSCSI
+	// options of the volume, the option is the rest of the key
+	ISCSIOptionPrefix = "iscsi.openebs.io/"
+
+	// DefaultTargetPort is the port of the iSCSI target
+	DefaultTargetPort = "3260"
+)
+
+// LUKS secret keys, the passphrase is read from the NodeStageVolume
+// secrets and both of them are read from the rekey secret
+const (
+	EncryptionPassphraseKey    = "encryptionPassphrase"
+	NewEncryptionPassphraseKey = "newEncryptionPassphrase"
+)
+
+// CHAP secret keys, these are the same as the ones used by the in-tree
+// iSCSI volume plugin
+const (
+	SessionCHAPUsernameKey     = "node.session.auth.username"
+	SessionCHAPPasswordKey     = "node.session.auth.password"
+	SessionCHAPUsernameInKey   = "node.session.auth.username_in"
+	SessionCHAPPasswordInKey   = "node.session.auth.password_in"
+	DiscoveryCHAPUsernameKey   = "discovery.sendtargets.auth.username"
+	DiscoveryCHAPPasswordKey   = "discovery.sendtargets.auth.password"
+	DiscoveryCHAPUsernameInKey = "discovery.sendtargets.auth.username_in"
+	DiscoveryCHAPPasswordInKey = "discovery.sendtargets.auth.password_in"
+)
+
+// CHAPSecretKeys are the keys of the CHAP credentials in the secrets
+var CHAPSecretKeys = []string{
+	SessionCHAPUsernameKey,
+	SessionCHAPPasswordKey,
+	SessionCHAPUsernameInKey,
+	SessionCHAPPasswordInKey,
+	DiscoveryCHAPUsernameKey,
+	DiscoveryCHAPPasswordKey,
+	DiscoveryCHAPUsernameInKey,
+	DiscoveryCHAPPasswordInKey,
+}
+
+// CHAPSecrets returns the CHAP credentials present in the secrets
+func CHAPSecrets(secrets map[string]string) map[string]string {
+	chap := map[string]string{}
+	for _, key := range CHAPSecretKeys {
+		if val, ok := secrets[key]; ok {
+			chap[key] = val
+		}
+	}
+	return chap
+}
+
+// ValidateCHAPSecrets verifies that the username and password of each of
+// the CHAP credentials are provided together
+func ValidateCHAPSecrets(secrets map[string]string) error {
+	pairs := [][2]string{
+		{SessionCHAPUsernameKey, SessionCHAPPasswordKey},
+		{SessionCHAPUsernameInKey, SessionCHAPPasswordInKey},
+		{DiscoveryCHAPUsernameKey, DiscoveryCHAPPasswordKey},
+		{DiscoveryCHAPUsernameInKey, DiscoveryCHAPPasswordInKey},
+	}
+	for _, pair := range pairs {
+		if (secrets[pair[0]] == "") != (secrets[pair[1]] == "") {
+			return fmt.Errorf("both %s and %s must be provided", pair[0], pair[1])
+		}
+	}
+
+	// mutual CHAP is only possible along with the initiator
+	// being authenticated
+	if secrets[SessionCHAPUsernameInKey] != "" && secrets[SessionCHAPUsernameKey] == "" {
+		return fmt.Errorf("%s must be provided along with %s", SessionCHAPUsernameKey, SessionCHAPUsernameInKey)
+	}
+	if secrets[DiscoveryCHAPUsernameInKey] != "" && secrets[DiscoveryCHAPUsernameKey] == "" {
+		return fmt.Errorf("%s must be provided along with %s", DiscoveryCHAPUsernameKey, DiscoveryCHAPUsernameInKey)
+	}
+	return nil
+}
+
+const (
+	// ReplicationFactorKey is the StorageClass parameter for the number
+	// of replicas of the volume
+	ReplicationFactorKey = "replicationFactor"
+	// ReplicaSCKey is the StorageClass parameter for the StorageClass of
+	// the PVs used by the replicas
+	ReplicaSCKey = "replicaSC"
+	// EnableBufioKey is the StorageClass parameter to enable buffered IO
+	// on the volume
+	EnableBufioKey = "enableBufio"
+	// TargetCPURequestKey is the StorageClass parameter for the CPU
+	// request of the target pod
+	TargetCPURequestKey = "targetCPURequest"
+	// TargetMemoryRequestKey is the StorageClass parameter for the memory
+	// request of the target pod
+	TargetMemoryRequestKey = "targetMemoryRequest"
+	// ReplicaCPURequestKey is the StorageClass parameter for the CPU
+	// request of the replica pods
+	ReplicaCPURequestKey = "replicaCPURequest"
+	// ReplicaMemoryRequestKey is the StorageClass parameter for the memory
+	// request of the replica pods
+	ReplicaMemoryRequestKey = "replicaMemoryRequest"
+	// RetentionPeriodParam is the StorageClass parameter for the duration
+	// for which the volume is retained after it is deleted
+	RetentionPeriodParam = "retentionPeriod"
+	// MultipathParam is the StorageClass parameter which enables logging
+	// in to the volume through multiple target portals
+	MultipathParam = "multipath"
+	// TargetPortalsParam is the StorageClass parameter for the comma
+	// separated list of additional target portals, like 10.0.0.1:3260
+	TargetPortalsParam = "targetPortals"
+	// EncryptedParam is the StorageClass parameter which enables LUKS
+	// encryption of the volume
+	EncryptedParam = "encrypted"
+	// ISCSIInterfaceParam is the StorageClass parameter for the iSCSI
+	// interface (iface) of the node through which the volume is logged in
+	ISCSIInterfaceParam = "iscsiInterface"
+	// ISCSILUNParam is the StorageClass parameter for the LUN of the
+	// volume on the target
+	ISCSILUNParam = "iscsiLUN"
+	// ReplacementTimeoutParam is the StorageClass parameter for the
+	// seconds for which IOs are queued while the session is reconnected
+	// before they are failed
+	ReplacementTimeoutParam = "replacementTimeout"
+	// NoopOutIntervalParam is the StorageClass parameter for the seconds
+	// between the NOP-Out pings sent to the target, 0 disables them
+	NoopOutIntervalParam = "noopOutInterval"
+	// NoopOutTimeoutParam is the StorageClass parameter for the seconds
+	// to wait for the NOP-Out response before the session is failed
+	NoopOutTimeoutParam = "noopOutTimeout"
+	// HeaderDigestParam is the StorageClass parameter for the header
+	// digest of the connection, like None or CRC32C
+	HeaderDigestParam = "headerDigest"
+	// DataDigestParam is the StorageClass parameter for the data digest
+	// of the connection, like None or CRC32C
+	DataDigestParam = "dataDigest"
+)
+
+// ISCSIParameters are the StorageClass parameters which tune the iSCSI
+// session of the volume on the nodes
+var ISCSIParameters = []string{
+	ISCSIInterfaceParam,
+	ISCSILUNParam,
+	ReplacementTimeoutParam,
+	NoopOutIntervalParam,
+	NoopOutTimeoutParam,
+	HeaderDigestParam,
+	DataDigestParam,
+}
+
+// PolicyParameters are the StorageClass parameters which are translated
+// directly into the policy of the JivaVolume
+var PolicyParameters = []string{
+	ReplicationFactorKey,
+	ReplicaSCKey,
+	EnableBufioKey,
+	TargetCPURequestKey,
+	TargetMemoryRequestKey,
+	ReplicaCPURequestKey,
+	ReplicaMemoryRequestKey,
+}
+
+// HasPolicyParameters checks if any of the policy fields is given inline in
+// the StorageClass parameters
+func HasPolicyParameters(params map[string]string) bool {
+	for _, key := range PolicyParameters {
+		if _, ok := params[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // Jiva wraps the JivaVolume structure
 type Jiva struct {
 	jvObj *jv.JivaVolume
@@ -124,3 +375,548 @@ func (j *Jiva) WithCapacity(capacity string) *Jiva {
 	j.jvObj.Spec.Capacity = capacity
 	return j
 }
+
+// WithPolicySpec defines the Policy field of JivaVolumeSpec
+func (j *Jiva) WithPolicySpec(spec jv.JivaVolumePolicySpec) *Jiva {
+	j.jvObj.Spec.Policy = spec
+	return j
+}
+
+// WithReplicationFactor defines the number of replicas of JivaVolume
+func (j *Jiva) WithReplicationFactor(rf string) *Jiva {
+	val, err := strconv.Atoi(rf)
+	if err != nil || val <= 0 {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: invalid %s {%v}", ReplicationFactorKey, rf))
+		return j
+	}
+	j.jvObj.Spec.Policy.Target.ReplicationFactor = val
+	return j
+}
+
+// WithReplicaSC defines the StorageClass of the PVs used by the replicas
+// of JivaVolume
+func (j *Jiva) WithReplicaSC(sc string) *Jiva {
+	if sc == "" {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: %s is empty", ReplicaSCKey))
+		return j
+	}
+	j.jvObj.Spec.Policy.ReplicaSC = sc
+	return j
+}
+
+// WithEnableBufio enables or disables buffered IO on JivaVolume
+func (j *Jiva) WithEnableBufio(enable string) *Jiva {
+	val, err := strconv.ParseBool(enable)
+	if err != nil {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: invalid %s {%v}", EnableBufioKey, enable))
+		return j
+	}
+	j.jvObj.Spec.Policy.EnableBufio = val
+	return j
+}
+
+// WithTargetResources defines the CPU and memory requests of the target
+// pod, values returned as "0" by HasResourceParameters are left unset
+func (j *Jiva) WithTargetResources(cpu, memory string) *Jiva {
+	j.jvObj.Spec.Policy.Target.Resources = j.withResourceRequests(
+		j.jvObj.Spec.Policy.Target.Resources, cpu, memory)
+	return j
+}
+
+// WithReplicaResources defines the CPU and memory requests of the replica
+// pods, values returned as "0" by HasResourceParameters are left unset
+func (j *Jiva) WithReplicaResources(cpu, memory string) *Jiva {
+	j.jvObj.Spec.Policy.Replica.Resources = j.withResourceRequests(
+		j.jvObj.Spec.Policy.Replica.Resources, cpu, memory)
+	return j
+}
+
+func (j *Jiva) withResourceRequests(
+	res *corev1.ResourceRequirements,
+	cpu, memory string,
+) *corev1.ResourceRequirements {
+
+	requests := map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    cpu,
+		corev1.ResourceMemory: memory,
+	}
+
+	for name, val := range requests {
+		if val == "" || val == "0" {
+			continue
+		}
+
+		qty, err := resource.ParseQuantity(val)
+		if err != nil {
+			j.Errs = append(j.Errs,
+				fmt.Errorf("failed to initialize JivaVolume: invalid %s request {%v}", name, val))
+			continue
+		}
+
+		if res == nil {
+			res = &corev1.ResourceRequirements{}
+		} else {
+			res = res.DeepCopy()
+		}
+
+		if res.Requests == nil {
+			res.Requests = corev1.ResourceList{}
+		}
+		res.Requests[name] = qty
+	}
+	return res
+}
+
+// WithVolumeID records the CSI volume ID to which the JivaVolume belongs
+func (j *Jiva) WithVolumeID(volumeID string) *Jiva {
+	if volumeID == "" {
+		j.Errs = append(j.Errs,
+			errors.New("failed to initialize JivaVolume: volume ID is missing"))
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[VolumeIDKey] = volumeID
+	return j
+}
+
+// WithParameters records the StorageClass parameters of the volume, the
+// PVC and PV metadata passed by the external-provisioner is left out
+func (j *Jiva) WithParameters(params map[string]string) *Jiva {
+	recorded := map[string]string{}
+	for key, val := range params {
+		if !strings.HasPrefix(key, "csi.storage.k8s.io/") {
+			recorded[key] = val
+		}
+	}
+
+	// keys of the map are sorted when encoded, so the same
+	// parameters are always recorded the same way
+	data, err := json.Marshal(recorded)
+	if err != nil {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: failed to encode parameters, err: {%v}", err))
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[ParametersKey] = string(data)
+	return j
+}
+
+// WithCHAPSecret records the secret holding the CHAP credentials with
+// which the target of the volume authenticates the initiators
+func (j *Jiva) WithCHAPSecret(name string) *Jiva {
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[CHAPSecretKey] = name
+	return j
+}
+
+// WithMultipath enables logging in to the volume through the target
+// service and pod IPs along with the given additional portals
+func (j *Jiva) WithMultipath(multipath, portals string) *Jiva {
+	enabled, err := strconv.ParseBool(multipath)
+	if err != nil {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: invalid %s {%v}", MultipathParam, multipath))
+		return j
+	}
+
+	if !enabled {
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[MultipathKey] = "true"
+	if portals != "" {
+		j.jvObj.Annotations[TargetPortalsKey] = strings.Join(ParsePortals(portals), ",")
+	}
+	return j
+}
+
+// WithEncryption enables LUKS encryption of the volume, the device is
+// formatted as LUKS by the node on which it is first staged
+func (j *Jiva) WithEncryption(encrypted string) *Jiva {
+	enabled, err := strconv.ParseBool(encrypted)
+	if err != nil {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: invalid %s {%v}", EncryptedParam, encrypted))
+		return j
+	}
+
+	if !enabled {
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[EncryptedKey] = "true"
+	return j
+}
+
+// IsEncrypted checks if the volume is encrypted with LUKS
+func IsEncrypted(instance *jv.JivaVolume) bool {
+	encrypted, _ := strconv.ParseBool(instance.Annotations[EncryptedKey])
+	return encrypted
+}
+
+// WithISCSIOptions records the iSCSI options given in the StorageClass
+// parameters, they are applied by the node when logging in to the volume
+func (j *Jiva) WithISCSIOptions(params map[string]string) *Jiva {
+	for _, key := range ISCSIParameters {
+		val, ok := params[key]
+		if !ok {
+			continue
+		}
+
+		if j.jvObj.Annotations == nil {
+			j.jvObj.Annotations = map[string]string{}
+		}
+		j.jvObj.Annotations[ISCSIOptionPrefix+key] = val
+	}
+	return j
+}
+
+// ISCSIOptions returns the iSCSI options of the volume keyed by their
+// StorageClass parameters
+func ISCSIOptions(instance *jv.JivaVolume) map[string]string {
+	opts := map[string]string{}
+	for _, key := range ISCSIParameters {
+		if val, ok := instance.Annotations[ISCSIOptionPrefix+key]; ok {
+			opts[key] = val
+		}
+	}
+	return opts
+}
+
+// IsMultipath checks if the volume is logged in through multiple target
+// portals
+func IsMultipath(instance *jv.JivaVolume) bool {
+	multipath, _ := strconv.ParseBool(instance.Annotations[MultipathKey])
+	return multipath
+}
+
+// TargetPortals returns the additional target portals of the volume
+func TargetPortals(instance *jv.JivaVolume) []string {
+	return ParsePortals(instance.Annotations[TargetPortalsKey])
+}
+
+// ParsePortals parses the comma separated list of portals, the default
+// iSCSI port is added to the portals without any
+func ParsePortals(portals string) []string {
+	var list []string
+	for _, p := range strings.Split(portals, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			p = net.JoinHostPort(p, DefaultTargetPort)
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// WithRetentionPeriod records the duration for which the JivaVolume is
+// retained after the volume is deleted, before it is purged
+func (j *Jiva) WithRetentionPeriod(period string) *Jiva {
+	if d, err := time.ParseDuration(period); err != nil || d <= 0 {
+		j.Errs = append(j.Errs,
+			fmt.Errorf("failed to initialize JivaVolume: invalid %s {%v}", RetentionPeriodParam, period))
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[RetentionPeriodKey] = period
+	return j
+}
+
+// WithPVSpec records the access modes and the volume mode requested by
+// the capabilities and the StorageClass of the volume, so that the PV of a
+// retained volume can be restored as it was
+func (j *Jiva) WithPVSpec(caps []*csi.VolumeCapability, storageClass string) *Jiva {
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+
+	var modes []string
+	seen := map[corev1.PersistentVolumeAccessMode]bool{}
+	volumeMode := corev1.PersistentVolumeFilesystem
+	for _, c := range caps {
+		mode := accessMode(c.GetAccessMode().GetMode())
+		if !seen[mode] {
+			seen[mode] = true
+			modes = append(modes, string(mode))
+		}
+		if c.GetBlock() != nil {
+			volumeMode = corev1.PersistentVolumeBlock
+		}
+	}
+
+	j.jvObj.Annotations[AccessModesKey] = strings.Join(modes, ",")
+	j.jvObj.Annotations[VolumeModeKey] = string(volumeMode)
+	if storageClass != "" {
+		j.jvObj.Annotations[StorageClassKey] = storageClass
+	}
+	return j
+}
+
+// accessMode returns the PV access mode from which the external-provisioner
+// derives the given CSI access mode
+func accessMode(mode csi.VolumeCapability_AccessMode_Mode) corev1.PersistentVolumeAccessMode {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return corev1.ReadOnlyMany
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return corev1.ReadWriteMany
+	default:
+		return corev1.ReadWriteOnce
+	}
+}
+
+// PVAccessModes returns the access modes of the PV of the volume recorded
+// at creation, volumes created before they were recorded are RWO
+func PVAccessModes(instance *jv.JivaVolume) []corev1.PersistentVolumeAccessMode {
+	modes := []corev1.PersistentVolumeAccessMode{}
+	for _, mode := range strings.Split(instance.Annotations[AccessModesKey], ",") {
+		if mode != "" {
+			modes = append(modes, corev1.PersistentVolumeAccessMode(mode))
+		}
+	}
+	if len(modes) == 0 {
+		modes = append(modes, corev1.ReadWriteOnce)
+	}
+	return modes
+}
+
+// PVVolumeMode returns the volume mode of the PV of the volume recorded
+// at creation
+func PVVolumeMode(instance *jv.JivaVolume) corev1.PersistentVolumeMode {
+	if mode := instance.Annotations[VolumeModeKey]; mode != "" {
+		return corev1.PersistentVolumeMode(mode)
+	}
+	return corev1.PersistentVolumeFilesystem
+}
+
+// WithVolumeName records the name of the volume requested by the CO
+func (j *Jiva) WithVolumeName(name string) *Jiva {
+	if name == "" {
+		j.Errs = append(j.Errs,
+			errors.New("failed to initialize JivaVolume: volume name is missing"))
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[VolumeNameKey] = name
+	return j
+}
+
+// VolumeID returns the CSI volume ID of the JivaVolume, volumes provisioned
+// by the older versions don't record it so their PV name is returned
+func VolumeID(instance *jv.JivaVolume) string {
+	if id := instance.Annotations[VolumeIDKey]; id != "" {
+		return id
+	}
+	return instance.Spec.PV
+}
+
+// VolumeName returns the name of the volume requested by the CO, volumes
+// provisioned by the older versions don't record it so their PV name is
+// returned
+func VolumeName(instance *jv.JivaVolume) string {
+	if name := instance.Annotations[VolumeNameKey]; name != "" {
+		return name
+	}
+	return instance.Spec.PV
+}
+
+// IsVolume checks if the JivaVolume belongs to the given CSI volume ID or
+// the volume name requested by the CO
+func IsVolume(instance *jv.JivaVolume, volumeID string) bool {
+	if VolumeID(instance) == volumeID {
+		return true
+	}
+
+	if name := instance.Annotations[VolumeNameKey]; name != "" {
+		return name == volumeID
+	}
+	return instance.Spec.PV == utils.StripName(volumeID) ||
+		instance.Spec.PV == utils.LegacyStripName(volumeID)
+}
+
+// WithSnapshotSource records the snapshot from which the JivaVolume will be
+// provisioned, replicas of the new volume are seeded from the given
+// snapshot of the source volume
+func (j *Jiva) WithSnapshotSource(srcVolume, snapshot string) *Jiva {
+	return j.withSource(SourceTypeSnapshot, srcVolume, snapshot)
+}
+
+// WithCloneSource records the volume from which the JivaVolume will be
+// cloned, replicas of the new volume are seeded from the given snapshot
+// taken on the source volume while provisioning the clone
+func (j *Jiva) WithCloneSource(srcVolume, snapshot string) *Jiva {
+	return j.withSource(SourceTypeVolume, srcVolume, snapshot)
+}
+
+func (j *Jiva) withSource(srcType, srcVolume, snapshot string) *Jiva {
+	if srcVolume == "" || snapshot == "" {
+		j.Errs = append(j.Errs,
+			errors.New("failed to initialize JivaVolume: source volume/snapshot or both are missing"))
+		return j
+	}
+
+	if j.jvObj.Annotations == nil {
+		j.jvObj.Annotations = map[string]string{}
+	}
+	j.jvObj.Annotations[SourceTypeKey] = srcType
+	j.jvObj.Annotations[SourceVolumeKey] = srcVolume
+	j.jvObj.Annotations[SourceSnapshotKey] = snapshot
+	return j
+}
+
+// IsSeeded checks if the JivaVolume has no content source or its replicas
+// have been seeded from it
+func IsSeeded(instance *jv.JivaVolume) bool {
+	return instance.Annotations[SourceTypeKey] == "" ||
+		instance.Annotations[SeededKey] == "true"
+}
+
+// HasTopology checks if the accessibility requirements restrict the nodes
+// on which the volume can be placed
+func HasTopology(req *csi.TopologyRequirement) bool {
+	for _, topology := range append(req.GetRequisite(), req.GetPreferred()...) {
+		if len(topology.GetSegments()) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTopology translates the accessibility requirements of the volume into
+// node affinity of the target and replica pods. Requisite topologies become
+// the required node selector terms (any one of them must match) and the
+// preferred topologies become preferred terms, weighted by their order.
+// The affinity is merged into the policy set on the JivaVolume, so the
+// policy must be set before.
+func (j *Jiva) WithTopology(req *csi.TopologyRequirement) *Jiva {
+	if req == nil {
+		return j
+	}
+
+	nodeAffinity := &corev1.NodeAffinity{}
+	var terms []corev1.NodeSelectorTerm
+	for _, topology := range req.GetRequisite() {
+		if term := nodeSelectorTerm(topology); term != nil {
+			terms = append(terms, *term)
+		}
+	}
+
+	if len(terms) != 0 {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: terms,
+		}
+	}
+
+	preferred := req.GetPreferred()
+	for i, topology := range preferred {
+		if term := nodeSelectorTerm(topology); term != nil {
+			nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+				nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+				corev1.PreferredSchedulingTerm{
+					// weight must be in the range 1-100
+					Weight:     int32(100 - (i * 99 / len(preferred))),
+					Preference: *term,
+				})
+		}
+	}
+
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil &&
+		len(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		return j
+	}
+
+	j.jvObj.Spec.Policy.Target.Affinity = mergeNodeAffinity(j.jvObj.Spec.Policy.Target.Affinity, nodeAffinity)
+	j.jvObj.Spec.Policy.Replica.Affinity = mergeNodeAffinity(j.jvObj.Spec.Policy.Replica.Affinity, nodeAffinity)
+	return j
+}
+
+// mergeNodeAffinity returns the affinity of the policy restricted further by
+// the node affinity of the topology. Pods must match both the required terms
+// of the policy and the topology, so every term of one is combined with
+// every term of the other. Preferred terms of both are kept.
+func mergeNodeAffinity(affinity *corev1.Affinity, topology *corev1.NodeAffinity) *corev1.Affinity {
+	if affinity == nil {
+		return &corev1.Affinity{NodeAffinity: topology.DeepCopy()}
+	}
+
+	merged := affinity.DeepCopy()
+	if merged.NodeAffinity == nil {
+		merged.NodeAffinity = topology.DeepCopy()
+		return merged
+	}
+
+	nodeAffinity := merged.NodeAffinity
+	if required := topology.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required.DeepCopy()
+		} else {
+			var terms []corev1.NodeSelectorTerm
+			for _, policyTerm := range nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, term := range required.NodeSelectorTerms {
+					combined := *policyTerm.DeepCopy()
+					combined.MatchExpressions = append(combined.MatchExpressions, term.DeepCopy().MatchExpressions...)
+					terms = append(terms, combined)
+				}
+			}
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms
+		}
+	}
+
+	for _, term := range topology.PreferredDuringSchedulingIgnoredDuringExecution {
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, *term.DeepCopy())
+	}
+	return merged
+}
+
+// nodeSelectorTerm returns the node selector term matching all the segments
+// of the given topology
+func nodeSelectorTerm(topology *csi.Topology) *corev1.NodeSelectorTerm {
+	if len(topology.GetSegments()) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(topology.GetSegments()))
+	for key := range topology.GetSegments() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	term := &corev1.NodeSelectorTerm{}
+	for _, key := range keys {
+		term.MatchExpressions = append(term.MatchExpressions, corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{topology.GetSegments()[key]},
+		})
+	}
+	return term
+}
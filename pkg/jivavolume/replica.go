@@ -0,0 +1,229 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jivavolume
+
+import (
+	"fmt"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// replicaContainer is the name of the jiva container of the replica
+	// pods created by jiva-operator
+	replicaContainer = "jiva-replica"
+	// replicaVolume is the name of the PVC template of the replicas
+	replicaVolume = "openebs"
+	// replicaServiceName is the governing service of the replica
+	// statefulsets created by jiva-operator
+	replicaServiceName = "jiva-replica-svc"
+	// ctrlSvcNameFormat is the address of the target service of a
+	// JivaVolume i.e <name>-jiva-ctrl-svc.<namespace>.svc.cluster.local
+	ctrlSvcNameFormat = "%s-jiva-ctrl-svc.%s.svc.cluster.local"
+)
+
+// ReplicaStatefulSetName returns the name of the statefulset of the
+// replicas of the given JivaVolume
+func ReplicaStatefulSetName(instance *jv.JivaVolume) string {
+	return instance.Name + "-jiva-rep"
+}
+
+// ReplicaClaimNames returns the names of the PVCs of the replicas created
+// from the template of the given statefulset
+func ReplicaClaimNames(sts *appsv1.StatefulSet) []string {
+	if sts.Spec.Replicas == nil {
+		return nil
+	}
+
+	names := []string{}
+	for i := 0; i < int(*sts.Spec.Replicas); i++ {
+		names = append(names, fmt.Sprintf("%s-%s-%d", replicaVolume, sts.Name, i))
+	}
+	return names
+}
+
+// ReplicaImage returns the image of the jiva replica of the given
+// statefulset
+func ReplicaImage(sts *appsv1.StatefulSet) string {
+	for _, c := range sts.Spec.Template.Spec.Containers {
+		if c.Name == replicaContainer {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+func replicaLabels(pv string) map[string]string {
+	return map[string]string{
+		"openebs.io/cas-type":          "jiva",
+		"openebs.io/component":         "jiva-replica",
+		"openebs.io/persistent-volume": pv,
+	}
+}
+
+// NewSeedReplicaStatefulSet returns the replica statefulset of the given
+// JivaVolume which seeds the replicas from the snapshot of the source
+// volume served at cloneIP. It is the same as the one jiva-operator
+// creates, except for the clone arguments of the replicas, so that
+// jiva-operator finds it already created and leaves it as it is.
+func NewSeedReplicaStatefulSet(
+	instance *jv.JivaVolume,
+	policy jv.JivaVolumePolicySpec,
+	image, cloneIP, snapshot string,
+) (*appsv1.StatefulSet, error) {
+	capacity, err := resource.ParseQuantity(instance.Spec.Capacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse capacity: {%v} of JivaVolume: {%v}, err: {%v}",
+			instance.Spec.Capacity, instance.Name, err)
+	}
+
+	if image == "" || cloneIP == "" || snapshot == "" {
+		return nil, fmt.Errorf("failed to build replicas of JivaVolume: {%v}, image, clone IP or snapshot is missing",
+			instance.Name)
+	}
+
+	labels := replicaLabels(instance.Spec.PV)
+	replicas := int32(policy.Target.ReplicationFactor)
+	privileged := true
+
+	affinity := &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: labels,
+					},
+					TopologyKey: "kubernetes.io/hostname",
+				},
+			},
+		},
+	}
+	if a := policy.Replica.Affinity; a != nil {
+		affinity.NodeAffinity = a.NodeAffinity
+	}
+
+	resources := corev1.ResourceRequirements{}
+	if policy.Replica.Resources != nil {
+		resources = *policy.Replica.Resources
+	}
+
+	return &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StatefulSet",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ReplicaStatefulSetName(instance),
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			ServiceName:         replicaServiceName,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Affinity:          affinity,
+					Tolerations:       policy.Replica.Tolerations,
+					NodeSelector:      policy.Replica.NodeSelector,
+					PriorityClassName: policy.Replica.PriorityClassName,
+					Containers: []corev1.Container{
+						{
+							Name:    replicaContainer,
+							Image:   image,
+							Command: []string{"launch"},
+							Args: []string{
+								"replica",
+								"--frontendIP",
+								fmt.Sprintf(ctrlSvcNameFormat, instance.Name, instance.Namespace),
+								"--cloneIP",
+								cloneIP,
+								"--type",
+								"clone",
+								"--snapName",
+								snapshot,
+								"--size",
+								fmt.Sprint(capacity.Value()),
+								replicaVolume,
+							},
+							Ports: []corev1.ContainerPort{
+								{ContainerPort: 9502, Protocol: corev1.ProtocolTCP},
+								{ContainerPort: 9503, Protocol: corev1.ProtocolTCP},
+								{ContainerPort: 9504, Protocol: corev1.ProtocolTCP},
+							},
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &privileged,
+							},
+							Resources: resources,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      replicaVolume,
+									MountPath: "/" + replicaVolume,
+								},
+							},
+						},
+					},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      replicaVolume,
+						Namespace: instance.Namespace,
+					},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						StorageClassName: &policy.ReplicaSC,
+						AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: capacity,
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// ControllerRef returns the owner reference which makes the given
+// JivaVolume the controller of the objects created for it
+func ControllerRef(instance *jv.JivaVolume) metav1.OwnerReference {
+	controller, blockOwnerDeletion := true, true
+	return metav1.OwnerReference{
+		APIVersion:         "openebs.io/v1alpha1",
+		Kind:               "JivaVolume",
+		Name:               instance.Name,
+		UID:                instance.UID,
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
@@ -28,19 +28,31 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"k8s.io/cloud-provider/volume/helpers"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 const (
-	defaultReplicaSC = "openebs-hostpath"
-	defaultNS        = "openebs"
-	defaultSizeBytes = 5 * helpers.GiB
+	defaultReplicaSC         = "openebs-hostpath"
+	defaultReplicationFactor = 3
+	defaultNS                = "openebs"
+	defaultSizeBytes         = 5 * helpers.GiB
+
+	// PVC of the volume passed by the external-provisioner
+	pvcNameParam      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceParam = "csi.storage.k8s.io/pvc/namespace"
 )
 
 // Client is the wrapper over the k8s client that will be used by
@@ -87,8 +99,42 @@ func (cl *Client) RegisterAPI(opts manager.Options) error {
 	return nil
 }
 
-// GetJivaVolume get the instance of JivaVolume CR.
-func (cl *Client) GetJivaVolume(name string) (*jv.JivaVolume, error) {
+// GetJivaVolume get the instance of JivaVolume CR of the given volume,
+// volumes with the IDs of the older versions are looked up by their PV
+// label as a fallback
+func (cl *Client) GetJivaVolume(volumeID string) (*jv.JivaVolume, error) {
+	if ns, name, ok := utils.ParseVolumeID(volumeID); ok {
+		instance := &jv.JivaVolume{}
+		err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance)
+		if err != nil && errors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "Failed to get JivaVolume CR: {%v}", volumeID)
+		} else if err != nil {
+			logrus.Errorf("Failed to get JivaVolume CR: %v, err: %v", volumeID, err)
+			return nil, status.Errorf(codes.Internal, "Failed to get JivaVolume CR: {%v}, err: {%v}", volumeID, err)
+		}
+		return instance, nil
+	}
+
+	instance, err := cl.getJivaVolume(utils.StripName(volumeID))
+	if status.Code(err) != codes.NotFound {
+		return instance, err
+	}
+
+	legacyName := utils.LegacyStripName(volumeID)
+	if legacyName == utils.StripName(volumeID) {
+		return nil, err
+	}
+
+	// Older versions truncated the long names, which may
+	// collide with the volume of another PV
+	legacy, legacyErr := cl.getJivaVolume(legacyName)
+	if legacyErr != nil || !jivavolume.IsVolume(legacy, volumeID) {
+		return nil, err
+	}
+	return legacy, nil
+}
+
+func (cl *Client) getJivaVolume(name string) (*jv.JivaVolume, error) {
 	instance, err := cl.ListJivaVolume(name)
 	if err != nil {
 		logrus.Errorf("Failed to get JivaVolume CR: %v, err: %v", name, err)
@@ -119,6 +165,19 @@ func getDefaultLabels(pv string) map[string]string {
 	}
 }
 
+func getNamespace(params map[string]string) string {
+	if ns, ok := params["namespace"]; ok {
+		return ns
+	}
+	return defaultNS
+}
+
+// VolumeID returns the CSI volume ID of the volume provisioned for the
+// given request
+func VolumeID(req *csi.CreateVolumeRequest) string {
+	return utils.VolumeID(getNamespace(req.GetParameters()), utils.StripName(req.GetName()))
+}
+
 func getdefaultAnnotations(policy string) map[string]string {
 	annotations := map[string]string{}
 	if policy != "" {
@@ -127,58 +186,328 @@ func getdefaultAnnotations(policy string) map[string]string {
 	return annotations
 }
 
-// CreateJivaVolume check whether JivaVolume CR already exists and creates one
-// if it doesn't exist.
-func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
-	var sizeBytes int64
-	name := utils.StripName(req.GetName())
-	policyName := req.GetParameters()["policy"]
-	ns, ok := req.GetParameters()["namespace"]
-	if !ok {
-		ns = defaultNS
+// getCapacity returns the capacity of the JivaVolume for the requested
+// range, volumes are provisioned in whole GiB so the required size is
+// rounded up and it must still fit within the limit
+func getCapacity(capRange *csi.CapacityRange) (string, error) {
+	sizeBytes := capRange.GetRequiredBytes()
+	limitBytes := capRange.GetLimitBytes()
+	if limitBytes != 0 && sizeBytes > limitBytes {
+		return "", status.Errorf(codes.InvalidArgument,
+			"CreateVolume: required bytes {%v} exceeds limit bytes {%v}", sizeBytes, limitBytes)
 	}
-	if req.GetCapacityRange() == nil {
-		logrus.Warningf("CreateVolume: capacity range is nil, provisioning with default size: {%v (bytes)}", defaultSizeBytes)
+
+	if sizeBytes == 0 {
 		sizeBytes = defaultSizeBytes
-	} else {
-		sizeBytes = req.GetCapacityRange().RequiredBytes
+		if limitBytes != 0 && limitBytes < sizeBytes {
+			sizeBytes = limitBytes
+		}
+		logrus.Warningf("CreateVolume: required bytes not provided, provisioning with size: {%v (bytes)}", sizeBytes)
 	}
 
 	size := resource.NewQuantity(sizeBytes, resource.BinarySI)
 	volSizeGiB := helpers.RoundUpToGiB(*size)
-	capacity := fmt.Sprintf("%dGi", volSizeGiB)
+	if limitBytes != 0 && volSizeGiB*helpers.GiB > limitBytes {
+		return "", status.Errorf(codes.OutOfRange,
+			"CreateVolume: size {%vGi} rounded up from required bytes {%v} exceeds limit bytes {%v}",
+			volSizeGiB, sizeBytes, limitBytes)
+	}
+	return fmt.Sprintf("%dGi", volSizeGiB), nil
+}
+
+// CreateJivaVolume check whether JivaVolume CR already exists and creates one
+// if it doesn't exist.
+func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
+	name := utils.StripName(req.GetName())
+	policyName := req.GetParameters()["policy"]
+	ns := getNamespace(req.GetParameters())
+
+	capacity, err := getCapacity(req.GetCapacityRange())
+	if err != nil {
+		return err
+	}
 	jiva := jivavolume.New().WithKindAndAPIVersion("JivaVolume", "openebs.io/v1alpha1").
 		WithNameAndNamespace(name, ns).
 		WithAnnotations(getdefaultAnnotations(policyName)).
 		WithLabels(getDefaultLabels(name)).
 		WithPV(name).
+		WithVolumeID(VolumeID(req)).
+		WithVolumeName(req.GetName()).
+		WithParameters(req.GetParameters()).
 		WithCapacity(capacity)
 
+	// source volume may have been named by the older versions,
+	// so its CR is looked up for the name
+	var src *jv.JivaVolume
+	var srcSnapshot string
+	if snap := req.GetVolumeContentSource().GetSnapshot(); snap != nil {
+		srcVolume, snapName, err := utils.ParseSnapshotID(snap.GetSnapshotId())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Failed to parse snapshot source, err: {%v}", err)
+		}
+
+		if src, err = cl.GetJivaVolume(srcVolume); err != nil {
+			return err
+		}
+		srcSnapshot = snapName
+		jiva.WithSnapshotSource(jivavolume.VolumeID(src), srcSnapshot)
+	} else if vol := req.GetVolumeContentSource().GetVolume(); vol != nil {
+		if src, err = cl.GetJivaVolume(vol.GetVolumeId()); err != nil {
+			return err
+		}
+		srcSnapshot = utils.CloneSnapshotName(name)
+		jiva.WithCloneSource(jivavolume.VolumeID(src), srcSnapshot)
+	}
+
+	// Policy fields given inline in the StorageClass are set on the
+	// JivaVolume directly, the rest of them are taken from the policy.
+	// Topology is merged into the resolved policy as well, since the
+	// policy set on the JivaVolume takes precedence over the named one.
+	if jivavolume.HasPolicyParameters(req.GetParameters()) ||
+		jivavolume.HasTopology(req.GetAccessibilityRequirements()) {
+		spec, err := cl.GetVolumePolicySpec(req.GetParameters())
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to get volume policy, err: {%v}", err)
+		}
+
+		res := jivavolume.HasResourceParameters(req)
+		jiva.WithPolicySpec(spec).
+			WithTargetResources(res(jivavolume.TargetCPURequestKey), res(jivavolume.TargetMemoryRequestKey)).
+			WithReplicaResources(res(jivavolume.ReplicaCPURequestKey), res(jivavolume.ReplicaMemoryRequestKey))
+	}
+
+	jiva.WithTopology(req.GetAccessibilityRequirements())
+
+	// PV of a retained volume is restored with the same spec
+	if period, ok := req.GetParameters()[jivavolume.RetentionPeriodParam]; ok {
+		sc, err := cl.getClaimStorageClass(req.GetParameters())
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to get StorageClass of the PVC, err: {%v}", err)
+		}
+		jiva.WithRetentionPeriod(period).
+			WithPVSpec(req.GetVolumeCapabilities(), sc)
+	}
+
+	if multipath, ok := req.GetParameters()[jivavolume.MultipathParam]; ok {
+		jiva.WithMultipath(multipath, req.GetParameters()[jivavolume.TargetPortalsParam])
+	}
+
+	jiva.WithISCSIOptions(req.GetParameters())
+
+	if encrypted, ok := req.GetParameters()[jivavolume.EncryptedParam]; ok {
+		jiva.WithEncryption(encrypted)
+	}
+
+	// CHAP credentials are kept in a secret owned by the JivaVolume
+	// so that they don't show up on the CR
+	chap := jivavolume.CHAPSecrets(req.GetSecrets())
+	if len(chap) != 0 {
+		jiva.WithCHAPSecret(name + "-chap")
+	}
+
 	if jiva.Errs != nil {
 		return status.Errorf(codes.Internal, "Failed to build JivaVolume CR, err: {%v}", jiva.Errs)
 	}
 
 	obj := jiva.Instance()
 	objExists := &jv.JivaVolume{}
-	err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, objExists)
+	err = cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, objExists)
 	if err != nil && errors.IsNotFound(err) {
+		if src != nil {
+			if err := cl.createSeedReplicas(obj, req.GetParameters(), src, srcSnapshot); err != nil {
+				return err
+			}
+		}
+
 		logrus.Infof("Creating a new JivaVolume CR {name: %v, namespace: %v}", name, ns)
 		err = cl.client.Create(context.TODO(), obj)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to create JivaVolume CR, err: {%v}", err)
 		}
-		return nil
+
+		if src != nil {
+			if _, err := cl.adoptSeedStatefulSet(obj); err != nil {
+				return status.Errorf(codes.Internal, "Failed to adopt the replicas of JivaVolume CR, err: {%v}", err)
+			}
+		}
+		return cl.applyCHAPSecret(obj, chap)
 	} else if err != nil {
 		return status.Errorf(codes.Internal, "Failed to get the JivaVolume details, err: {%v}", err)
 	}
 
+	if !jivavolume.IsVolume(objExists, req.GetName()) {
+		return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume {%v} belongs to a different PV {%v}",
+			name, jivavolume.VolumeID(objExists))
+	}
+
 	if objExists.Spec.Capacity != obj.Spec.Capacity {
 		return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume with different size already exists")
 	}
 
+	// parameters are not recorded on the CRs of the older versions
+	if params, ok := objExists.Annotations[jivavolume.ParametersKey]; ok && params != obj.Annotations[jivavolume.ParametersKey] {
+		return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume with different parameters {%v} already exists",
+			params)
+	}
+
+	for _, key := range []string{
+		jivavolume.SourceTypeKey,
+		jivavolume.SourceVolumeKey,
+		jivavolume.SourceSnapshotKey,
+	} {
+		if objExists.Annotations[key] != obj.Annotations[key] {
+			return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume with different content source already exists")
+		}
+	}
+
+	if objExists.Annotations[jivavolume.CHAPSecretKey] != obj.Annotations[jivavolume.CHAPSecretKey] {
+		return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume with different authentication already exists")
+	}
+
+	// CR may have been created by an earlier request which failed
+	// before adopting the replicas
+	if src != nil && !jivavolume.IsSeeded(objExists) {
+		if _, err := cl.adoptSeedStatefulSet(objExists); err != nil {
+			return status.Errorf(codes.Internal, "Failed to adopt the replicas of JivaVolume CR, err: {%v}", err)
+		}
+	}
+
+	return cl.applyCHAPSecret(objExists, chap)
+}
+
+// applyCHAPSecret creates or updates the secret holding the CHAP
+// credentials of the volume, the secret is garbage collected along with
+// the JivaVolume CR
+func (cl *Client) applyCHAPSecret(owner *jv.JivaVolume, chap map[string]string) error {
+	if len(chap) == 0 {
+		return nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      owner.Annotations[jivavolume.CHAPSecretKey],
+			Namespace: owner.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				jivavolume.ControllerRef(owner),
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: chap,
+	}
+
+	err := cl.client.Create(context.TODO(), secret)
+	if err != nil && errors.IsAlreadyExists(err) {
+		existing := &corev1.Secret{}
+		if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, existing); err != nil {
+			return status.Errorf(codes.Internal, "Failed to get CHAP secret {%v}, err: {%v}", secret.Name, err)
+		}
+		existing.Data = nil
+		existing.StringData = chap
+		err = cl.client.Update(context.TODO(), existing)
+	}
+
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to apply CHAP secret {%v}, err: {%v}", secret.Name, err)
+	}
+	return nil
+}
+
+// createSeedReplicas creates the replica statefulset of the JivaVolume which
+// seeds its replicas from the snapshot of the source volume. jiva-operator
+// doesn't know about the content source, it bootstraps the volume as soon
+// as the CR is created, so the statefulset is created before the CR for
+// jiva-operator to find it already there.
+func (cl *Client) createSeedReplicas(obj *jv.JivaVolume, params map[string]string, src *jv.JivaVolume, snapshot string) error {
+	if src.Spec.ISCSISpec.TargetIP == "" {
+		return status.Errorf(codes.FailedPrecondition, "Failed to seed JivaVolume CR, target of source volume {%v} is not created",
+			src.Name)
+	}
+
+	// replicas of the clone must run the same version of jiva as the
+	// source replicas they are seeded from
+	srcReplicas := &appsv1.StatefulSet{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{
+		Name:      jivavolume.ReplicaStatefulSetName(src),
+		Namespace: src.Namespace,
+	}, srcReplicas); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "Failed to get the replicas of source volume {%v}, err: {%v}", src.Name, err)
+	}
+
+	// policy is set on the CR only if some of its fields are given
+	// inline, otherwise it is resolved the same way as jiva-operator does
+	policy := obj.Spec.Policy
+	if policy.ReplicaSC == "" {
+		spec, err := cl.GetVolumePolicySpec(params)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to get volume policy, err: {%v}", err)
+		}
+		spec.Replica.Affinity = policy.Replica.Affinity
+		policy = spec
+	}
+
+	sts, err := jivavolume.NewSeedReplicaStatefulSet(obj, policy,
+		jivavolume.ReplicaImage(srcReplicas), src.Spec.ISCSISpec.TargetIP, snapshot)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to build the replicas of JivaVolume CR, err: {%v}", err)
+	}
+
+	logrus.Infof("Creating replicas of JivaVolume CR {name: %v, namespace: %v} seeded from snapshot {%v} of volume {%v}",
+		obj.Name, obj.Namespace, snapshot, src.Name)
+	if err := cl.client.Create(context.TODO(), sts); err != nil && !errors.IsAlreadyExists(err) {
+		return status.Errorf(codes.Internal, "Failed to create the replicas of JivaVolume CR, err: {%v}", err)
+	}
 	return nil
 }
 
+// adoptSeedStatefulSet makes the JivaVolume the controller of the replica
+// statefulset created to seed it, so that it is deleted along with the CR
+func (cl *Client) adoptSeedStatefulSet(instance *jv.JivaVolume) (*appsv1.StatefulSet, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{
+		Name:      jivavolume.ReplicaStatefulSetName(instance),
+		Namespace: instance.Namespace,
+	}, sts); err != nil {
+		return nil, err
+	}
+	return sts, cl.adopt(instance, sts)
+}
+
+// AdoptSeedReplicas makes the JivaVolume the controller of the replica
+// statefulset and the PVCs of the replicas which were created to seed it,
+// jiva-operator sets the same owner on the replicas it creates
+func (cl *Client) AdoptSeedReplicas(instance *jv.JivaVolume) error {
+	sts, err := cl.adoptSeedStatefulSet(instance)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range jivavolume.ReplicaClaimNames(sts) {
+		pvc, err := cl.GetPersistentVolumeClaim(name, instance.Namespace)
+		if err != nil {
+			return err
+		}
+
+		if err := cl.adopt(instance, pvc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adopt sets the JivaVolume as the controller of the given object unless it
+// already has one
+func (cl *Client) adopt(instance *jv.JivaVolume, obj interface {
+	metav1.Object
+	runtime.Object
+}) error {
+	if metav1.GetControllerOf(obj) != nil {
+		return nil
+	}
+
+	obj.SetOwnerReferences(append(obj.GetOwnerReferences(), jivavolume.ControllerRef(instance)))
+	return cl.client.Update(context.TODO(), obj)
+}
+
 // ListJivaVolume returns the list of JivaVolume resources
 func (cl *Client) ListJivaVolume(volumeID string) (*jv.JivaVolumeList, error) {
 	volumeID = utils.StripName(volumeID)
@@ -208,22 +537,235 @@ func (cl *Client) ListJivaVolumeWithOpts(opts map[string]string) (*jv.JivaVolume
 	return obj, nil
 }
 
-// DeleteJivaVolume delete the JivaVolume CR
-func (cl *Client) DeleteJivaVolume(volumeID string) error {
-	obj, err := cl.ListJivaVolume(volumeID)
+// WatchJivaVolumes runs an informer of the JivaVolumes which calls the
+// handler on their changes, it blocks until stop is closed
+func (cl *Client) WatchJivaVolumes(handler toolscache.ResourceEventHandler, stop <-chan struct{}) error {
+	informers, err := cache.New(cl.cfg, cache.Options{})
 	if err != nil {
 		return err
 	}
 
-	if len(obj.Items) == 0 {
+	informer, err := informers.GetInformer(&jv.JivaVolume{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(handler)
+	return informers.Start(stop)
+}
+
+// DeleteJivaVolume delete the JivaVolume CR
+func (cl *Client) DeleteJivaVolume(volumeID string) error {
+	instance, err := cl.GetJivaVolume(volumeID)
+	if status.Code(err) == codes.NotFound {
 		logrus.Warningf("DeleteVolume: JivaVolume: {%v}, not found, ignore deletion...", volumeID)
 		return nil
+	} else if err != nil {
+		return err
 	}
 
-	logrus.Debugf("DeleteVolume: object: {%+v}", obj)
-	instance := obj.Items[0].DeepCopy()
+	logrus.Debugf("DeleteVolume: object: {%+v}", instance)
 	if err := cl.client.Delete(context.TODO(), instance); err != nil {
 		return err
 	}
 	return nil
 }
+
+// GetJivaVolumePolicy get the instance of JivaVolumePolicy CR
+func (cl *Client) GetJivaVolumePolicy(name, ns string) (*jv.JivaVolumePolicy, error) {
+	instance := &jv.JivaVolumePolicy{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetStorageClass get the instance of StorageClass
+func (cl *Client) GetStorageClass(name string) (*storagev1.StorageClass, error) {
+	instance := &storagev1.StorageClass{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetNode get the instance of node
+func (cl *Client) GetNode(name string) (*corev1.Node, error) {
+	instance := &corev1.Node{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetSecret returns the secret with the given name
+func (cl *Client) GetSecret(name, ns string) (*corev1.Secret, error) {
+	instance := &corev1.Secret{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetEndpoints returns the endpoints of the given service
+func (cl *Client) GetEndpoints(name, ns string) (*corev1.Endpoints, error) {
+	instance := &corev1.Endpoints{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetPersistentVolumeClaim returns the PVC with the given name
+func (cl *Client) GetPersistentVolumeClaim(name, ns string) (*corev1.PersistentVolumeClaim, error) {
+	instance := &corev1.PersistentVolumeClaim{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// getClaimStorageClass returns the StorageClass of the PVC of the volume,
+// the PVC is known only if the external-provisioner passes it with
+// --extra-create-metadata
+func (cl *Client) getClaimStorageClass(params map[string]string) (string, error) {
+	name, ns := params[pvcNameParam], params[pvcNamespaceParam]
+	if name == "" || ns == "" {
+		return "", nil
+	}
+
+	pvc, err := cl.GetPersistentVolumeClaim(name, ns)
+	if err != nil {
+		return "", err
+	}
+	if pvc.Spec.StorageClassName == nil {
+		return "", nil
+	}
+	return *pvc.Spec.StorageClassName, nil
+}
+
+// GetPersistentVolume returns the PV with the given name
+func (cl *Client) GetPersistentVolume(name string) (*corev1.PersistentVolume, error) {
+	instance := &corev1.PersistentVolume{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// CreateEvent creates the given event
+func (cl *Client) CreateEvent(event *corev1.Event) error {
+	return cl.client.Create(context.TODO(), event)
+}
+
+// CreatePersistentVolume creates the given PV
+func (cl *Client) CreatePersistentVolume(pv *corev1.PersistentVolume) error {
+	return cl.client.Create(context.TODO(), pv)
+}
+
+// ScaleTarget scales the target deployment of the JivaVolume to the given
+// number of replicas
+func (cl *Client) ScaleTarget(instance *jv.JivaVolume, replicas int32) error {
+	dep := &appsv1.Deployment{}
+	name := instance.Name + "-jiva-ctrl"
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: instance.Namespace}, dep); err != nil {
+		return err
+	}
+
+	if dep.Spec.Replicas != nil && *dep.Spec.Replicas == replicas {
+		return nil
+	}
+
+	dep.Spec.Replicas = &replicas
+	return cl.client.Update(context.TODO(), dep)
+}
+
+// ListNodes returns the list of nodes with the given labels
+func (cl *Client) ListNodes(labels map[string]string) (*corev1.NodeList, error) {
+	obj := &corev1.NodeList{}
+	options := []client.ListOption{
+		client.MatchingLabels(labels),
+	}
+
+	if err := cl.client.List(context.TODO(), obj, options...); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// PatchNodeAnnotation sets the annotation of the given node with a merge
+// patch of just the annotation, so that it doesn't conflict with the
+// updates of the node by kubelet
+func (cl *Client) PatchNodeAnnotation(node *corev1.Node, key, value string) error {
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[key] = value
+	return cl.client.Patch(context.TODO(), node, patch)
+}
+
+// ListStorageClasses returns the list of StorageClasses
+func (cl *Client) ListStorageClasses() (*storagev1.StorageClassList, error) {
+	obj := &storagev1.StorageClassList{}
+	if err := cl.client.List(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ListPersistentVolumes returns the list of PVs
+func (cl *Client) ListPersistentVolumes() (*corev1.PersistentVolumeList, error) {
+	obj := &corev1.PersistentVolumeList{}
+	if err := cl.client.List(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// GetVolumePolicySpec returns the policy spec which will be applied to the
+// JivaVolume provisioned with the given parameters, defaults are filled the
+// same way as jiva-operator does while bootstrapping the volume
+func (cl *Client) GetVolumePolicySpec(params map[string]string) (jv.JivaVolumePolicySpec, error) {
+	spec := jv.JivaVolumePolicySpec{}
+	if policyName := params["policy"]; policyName != "" {
+		ns, ok := params["namespace"]
+		if !ok {
+			ns = defaultNS
+		}
+
+		policy, err := cl.GetJivaVolumePolicy(policyName, ns)
+		if err != nil {
+			return spec, err
+		}
+		spec = policy.Spec
+	}
+
+	// parameters given inline in the StorageClass take
+	// precedence over the fields of the policy
+	jiva := jivavolume.New().WithPolicySpec(spec)
+	if rf, ok := params[jivavolume.ReplicationFactorKey]; ok {
+		jiva.WithReplicationFactor(rf)
+	}
+
+	if sc, ok := params[jivavolume.ReplicaSCKey]; ok {
+		jiva.WithReplicaSC(sc)
+	}
+
+	if bufio, ok := params[jivavolume.EnableBufioKey]; ok {
+		jiva.WithEnableBufio(bufio)
+	}
+
+	if jiva.Errs != nil {
+		return spec, fmt.Errorf("invalid volume policy parameters, err: {%v}", jiva.Errs)
+	}
+
+	spec = jiva.Instance().Spec.Policy
+	if spec.ReplicaSC == "" {
+		spec.ReplicaSC = defaultReplicaSC
+	}
+
+	if spec.Target.ReplicationFactor == 0 {
+		spec.Target.ReplicationFactor = defaultReplicationFactor
+	}
+	return spec, nil
+}
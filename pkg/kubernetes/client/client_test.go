@@ -0,0 +1,159 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-operator/pkg/apis"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const zoneKey = "topology.kubernetes.io/zone"
+
+// newFakeClient returns the client backed by a fake API server holding the
+// given objects
+func newFakeClient(t *testing.T, objs ...runtime.Object) *Client {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to register the jiva-operator API: %v", err)
+	}
+	return &Client{client: fake.NewFakeClientWithScheme(scheme, objs...)}
+}
+
+func TestCreateJivaVolumeWithPolicyAndTopology(t *testing.T) {
+	policy := &jv.JivaVolumePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "ssd", Namespace: defaultNS},
+		Spec: jv.JivaVolumePolicySpec{
+			ReplicaSC: "ssd-hostpath",
+			Target: jv.TargetSpec{
+				ReplicationFactor: 2,
+			},
+			Replica: jv.ReplicaSpec{
+				PodTemplateResources: jv.PodTemplateResources{
+					Affinity: &corev1.Affinity{
+						NodeAffinity: &corev1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+								NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+									MatchExpressions: []corev1.NodeSelectorRequirement{{
+										Key:      "disk",
+										Operator: corev1.NodeSelectorOpIn,
+										Values:   []string{"ssd"},
+									}},
+								}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	req := &csi.CreateVolumeRequest{
+		Name:          "pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+		Parameters:    map[string]string{"policy": "ssd"},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{Segments: map[string]string{zoneKey: "zone-a"}},
+			},
+		},
+	}
+
+	cl := newFakeClient(t, policy)
+	if err := cl.CreateJivaVolume(req); err != nil {
+		t.Fatalf("CreateJivaVolume failed: %v", err)
+	}
+
+	instance := &jv.JivaVolume{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: "pvc-1", Namespace: defaultNS}, instance); err != nil {
+		t.Fatalf("failed to get JivaVolume: %v", err)
+	}
+
+	spec := instance.Spec.Policy
+	if spec.ReplicaSC != "ssd-hostpath" || spec.Target.ReplicationFactor != 2 {
+		t.Errorf("got replicaSC %q and replication factor %d, want the ones of the named policy",
+			spec.ReplicaSC, spec.Target.ReplicationFactor)
+	}
+
+	// replicas must match both the policy and the topology
+	replicaTerms := requiredTerms(t, spec.Replica.Affinity)
+	if len(replicaTerms) != 1 || !hasKeys(replicaTerms[0], "disk", zoneKey) {
+		t.Errorf("got replica node selector terms %+v, want the policy term combined with the topology", replicaTerms)
+	}
+
+	targetTerms := requiredTerms(t, spec.Target.Affinity)
+	if len(targetTerms) != 1 || !hasKeys(targetTerms[0], zoneKey) {
+		t.Errorf("got target node selector terms %+v, want the topology term", targetTerms)
+	}
+}
+
+func TestCreateJivaVolumeWithDifferentParameters(t *testing.T) {
+	newReq := func(rf string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "pvc-1",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30},
+			Parameters: map[string]string{
+				"replicationFactor":                rf,
+				"csi.storage.k8s.io/pvc/name":      "claim",
+				"csi.storage.k8s.io/pvc/namespace": "default",
+			},
+		}
+	}
+
+	cl := newFakeClient(t)
+	if err := cl.CreateJivaVolume(newReq("3")); err != nil {
+		t.Fatalf("CreateJivaVolume failed: %v", err)
+	}
+
+	if err := cl.CreateJivaVolume(newReq("3")); err != nil {
+		t.Errorf("CreateJivaVolume with the same parameters failed: %v", err)
+	}
+
+	if err := cl.CreateJivaVolume(newReq("2")); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v, want AlreadyExists for different parameters", err)
+	}
+}
+
+func requiredTerms(t *testing.T, affinity *corev1.Affinity) []corev1.NodeSelectorTerm {
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		t.Fatalf("got affinity %+v, want required node affinity", affinity)
+	}
+	return affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+}
+
+func hasKeys(term corev1.NodeSelectorTerm, keys ...string) bool {
+	if len(term.MatchExpressions) != len(keys) {
+		return false
+	}
+	for i, expr := range term.MatchExpressions {
+		if expr.Key != keys[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,15 +1,54 @@
 package utils
 
-import "strings"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
 
-const maxNameLen = 43
+const (
+	maxNameLen = 43
+
+	// nameHashLen is the length of the hash suffixed to the names which
+	// are truncated
+	nameHashLen = 8
+
+	// volumeIDVersion is the format version of the volume IDs encoding
+	// the namespace and name of the JivaVolume
+	volumeIDVersion = "v1"
+	// volumeIDSeparator separates the fields of the volume ID i.e
+	// <version>/<namespace>/<name>
+	volumeIDSeparator = "/"
+
+	// snapshotIDSeparator separates the source volume and the snapshot
+	// name in the snapshot ID i.e <volume-id>@<snapshot-name>
+	snapshotIDSeparator = "@"
+)
 
 // StripName strips the extra characters from the name
 // Since Custom Resources only support names upto 63 chars
 // so this trims the rest of the trailing chars and it generates
 // the controller-revision hash of by appending more 10 chars
 // after appending `-jiva-rep-` so total 20 chars must be stripped
+//
+// Names longer than the limit are truncated and suffixed with the hash of
+// the full name, so that names with a common prefix don't collide
 func StripName(name string) string {
+	name = strings.ToLower(name)
+	if len(name) <= maxNameLen {
+		return strings.TrimSuffix(name, "-")
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:nameHashLen]
+	prefix := strings.TrimSuffix(name[:maxNameLen-nameHashLen-1], "-")
+	return prefix + "-" + hash
+}
+
+// LegacyStripName strips the extra characters from the name by plain
+// truncation, volumes provisioned by the older versions are named this way
+func LegacyStripName(name string) string {
 	name = strings.ToLower(name)
 	if len(name) > maxNameLen {
 		name = name[:maxNameLen]
@@ -20,3 +59,42 @@ func StripName(name string) string {
 	}
 	return name
 }
+
+// VolumeID returns the CSI volume ID of the JivaVolume with the given
+// namespace and name, so that it can be looked up directly
+func VolumeID(ns, name string) string {
+	return strings.Join([]string{volumeIDVersion, ns, name}, volumeIDSeparator)
+}
+
+// ParseVolumeID splits the CSI volume ID into the namespace and name of the
+// JivaVolume, false is returned for the IDs of the volumes provisioned by
+// the older versions which are the PV names
+func ParseVolumeID(id string) (string, string, bool) {
+	s := strings.Split(id, volumeIDSeparator)
+	if len(s) != 3 || s[0] != volumeIDVersion || s[1] == "" || s[2] == "" {
+		return "", "", false
+	}
+	return s[1], s[2], true
+}
+
+// SnapshotID returns the CSI snapshot ID for the given snapshot, this is
+// stable so that retries from the snapshotter resolve to the same snapshot
+func SnapshotID(volumeID, name string) string {
+	return volumeID + snapshotIDSeparator + name
+}
+
+// ParseSnapshotID splits the CSI snapshot ID into source volume ID and
+// snapshot name
+func ParseSnapshotID(id string) (string, string, error) {
+	s := strings.SplitN(id, snapshotIDSeparator, 2)
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", fmt.Errorf("invalid snapshot ID: {%v}", id)
+	}
+	return s[0], s[1], nil
+}
+
+// CloneSnapshotName returns the name of the snapshot taken on the source
+// volume to seed the clone with the given name
+func CloneSnapshotName(name string) string {
+	return "clone-" + StripName(name)
+}